@@ -0,0 +1,185 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// aeadIO implements IO with an AEAD cipher, authenticating the envelope header
+// and the object name as associated data, so an encrypted object cannot be
+// swapped with another one in the bucket without failing to decrypt. Objects
+// are written in chunks (see streamSealer) and decrypted while they are read.
+// Objects encrypted in one piece remain readable. Legacy objects encrypted by
+// SecretBoxIO, which are not bound to their name, are rejected unless
+// AllowLegacySecretBox is called.
+type aeadIO struct {
+	algorithm Algorithm
+	key       [32]byte
+	newAEAD   func(key []byte) (cipher.AEAD, error)
+	aead      cipher.AEAD
+	legacy    *SecretBoxIO
+}
+
+func newAEADIO(algorithm Algorithm, key [32]byte, newAEAD func([]byte) (cipher.AEAD, error)) (aeadIO, error) {
+	aead, err := newAEAD(key[:])
+	if err != nil {
+		return aeadIO{}, err
+	}
+	return aeadIO{algorithm, key, newAEAD, aead, nil}, nil
+}
+
+// errUnboundObject is returned for objects encrypted with secretbox by IO
+// implementations that bind objects to their name.
+var errUnboundObject = errors.New("decryption failed: object was encrypted with secretbox, which does not bind it to its name")
+
+// legacyReader is implemented by IO implementations that can read legacy
+// secretbox objects while a bucket is migrated.
+type legacyReader interface {
+	AllowLegacySecretBox()
+}
+
+// AllowLegacySecretBox enables decrypting objects written by SecretBoxIO with
+// the same key. It should only be enabled until these objects are migrated.
+func (a *aeadIO) AllowLegacySecretBox() {
+	a.legacy = NewSecretBoxIO(a.key)
+}
+
+// XChaChaIO encrypts with XChaCha20-Poly1305.
+type XChaChaIO struct {
+	aeadIO
+}
+
+func NewXChaChaIO(key [32]byte) (*XChaChaIO, error) {
+	a, err := newAEADIO(AlgorithmXChaCha20Poly1305, key, chacha20poly1305.NewX)
+	if err != nil {
+		return nil, err
+	}
+	return &XChaChaIO{a}, nil
+}
+
+// AESGCMIO encrypts with AES-256-GCM, for environments that require FIPS-approved ciphers.
+type AESGCMIO struct {
+	aeadIO
+}
+
+func NewAESGCMIO(key [32]byte) (*AESGCMIO, error) {
+	a, err := newAEADIO(AlgorithmAES256GCM, key, newGCM)
+	if err != nil {
+		return nil, err
+	}
+	return &AESGCMIO{a}, nil
+}
+
+func (a *aeadIO) WrapReader(name string, r io.Reader) io.Reader {
+	if a.key == ([32]byte{}) {
+		return &Reader{nil, 0, errKeyWiped}
+	}
+
+	env, header, br, ok, err := peekEnvelope(r)
+	if err != nil {
+		return &Reader{nil, 0, err}
+	}
+	if ok && env.Version == EnvelopeVersionStream {
+		if env.Algorithm != a.algorithm {
+			return &Reader{nil, 0, fmt.Errorf("decryption failed: object uses %s instead of %s", env.Algorithm, a.algorithm)}
+		}
+		_, _ = br.Discard(len(header))
+		return a.openStream(name, header, br)
+	}
+
+	allData, err := io.ReadAll(br)
+	if err != nil {
+		return &Reader{nil, 0, err}
+	}
+
+	if len(allData) == 0 {
+		return bytes.NewReader(nil)
+	}
+
+	env, payload, ok := ParseEnvelope(allData)
+	if !ok || env.Algorithm == AlgorithmSecretBox {
+		if a.legacy == nil {
+			return &Reader{nil, 0, errUnboundObject}
+		}
+		return a.legacy.WrapReader(name, bytes.NewReader(allData))
+	}
+	if env.Algorithm != a.algorithm {
+		return &Reader{nil, 0, fmt.Errorf("decryption failed: object uses %s instead of %s", env.Algorithm, a.algorithm)}
+	}
+	return openReader(a, name, allData, ok, payload)
+}
+
+func (a *aeadIO) ByteReader(name string, msg []byte) Reader {
+	return a.seal(name, msg, "")
+}
+
+func (a *aeadIO) seal(name string, msg []byte, keyID string) Reader {
+	if a.key == ([32]byte{}) {
+		return Reader{nil, 0, errKeyWiped}
+	}
+	return newStreamSealer(a.newAEAD, a.key, a.algorithm, name, msg, keyID)
+}
+
+func (a *aeadIO) open(name string, header, payload []byte) ([]byte, error) {
+	if env, _, ok := ParseEnvelope(header); ok && env.Version == EnvelopeVersionStream {
+		return io.ReadAll(a.openStream(name, header, bytes.NewReader(payload)))
+	}
+	return aeadOpen(a.aead, name, header, payload)
+}
+
+// openStream returns a reader decrypting the payload of a streamed object from r.
+func (a *aeadIO) openStream(name string, header []byte, r io.Reader) io.Reader {
+	return newStreamOpener(a.newAEAD, a.key, name, header, r)
+}
+
+func (a *aeadIO) wipe() {
+	clear(a.key[:])
+	if a.legacy != nil {
+		a.legacy.wipe()
+	}
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func aeadOpen(aead cipher.AEAD, name string, header, payload []byte) ([]byte, error) {
+	if len(header) == 0 {
+		return nil, errors.New("decryption failed: missing envelope")
+	}
+	if len(payload) < aead.NonceSize() {
+		return nil, errors.New("insufficient data for decryption: missing nonce")
+	}
+
+	nonce, ciphertext := payload[:aead.NonceSize()], payload[aead.NonceSize():]
+	bout, err := aead.Open(nil, nonce, ciphertext, associatedData(header, name))
+	if err != nil {
+		return nil, errors.New("decryption failed: invalid key, corrupted data or object was moved")
+	}
+	return bout, nil
+}
+
+// associatedData binds the ciphertext to its envelope and object name.
+func associatedData(header []byte, name string) []byte {
+	ad := make([]byte, 0, len(header)+len(name))
+	ad = append(ad, header...)
+	return append(ad, name...)
+}
+
+var (
+	_ streamCipher = (*XChaChaIO)(nil)
+	_ streamCipher = (*AESGCMIO)(nil)
+	_ legacyReader = (*XChaChaIO)(nil)
+	_ legacyReader = (*AESGCMIO)(nil)
+	_ legacyReader = (*KeyringIO)(nil)
+)
@@ -0,0 +1,145 @@
+package s3
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"filippo.io/age"
+)
+
+// AgeIO encrypts to age X25519 recipients, so that nodes which only store
+// certificates need nothing but the public keys, while reading requires one of
+// the identities (private keys). The object name is encrypted along with the
+// data and checked on decryption, so objects cannot be swapped in the bucket.
+// This provides confidentiality only, not authenticity: anyone with a recipient
+// and write access to the bucket can create a valid object for any name, e.g.
+// a forged private key or certificate.
+// The payload following the envelope is a binary age file containing
+//
+//	name length (2 bytes) | name | data
+type AgeIO struct {
+	recipients []age.Recipient
+	identities []age.Identity
+}
+
+// NewAgeIO returns an AgeIO that encrypts to recipients and decrypts with
+// identities. Either may be empty, to only allow reading or writing.
+func NewAgeIO(recipients []age.Recipient, identities []age.Identity) *AgeIO {
+	return &AgeIO{recipients: recipients, identities: identities}
+}
+
+// ParseAgeRecipients parses age X25519 public keys (age1...).
+func ParseAgeRecipients(keys []string) ([]age.Recipient, error) {
+	recipients := make([]age.Recipient, 0, len(keys))
+	for _, key := range keys {
+		r, err := age.ParseX25519Recipient(key)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, r)
+	}
+	return recipients, nil
+}
+
+// LoadAgeIdentities reads the age identities from the file at path.
+func LoadAgeIdentities(path string) ([]age.Identity, error) {
+	f, err := os.Open(path) // #nosec G304 -- path is provided by the operator
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	return age.ParseIdentities(f)
+}
+
+func (a *AgeIO) WrapReader(name string, r io.Reader) io.Reader {
+	if len(a.identities) == 0 {
+		return &Reader{nil, 0, errors.New("decryption failed: no age identity configured, this node can only write")}
+	}
+
+	env, header, br, ok, err := peekEnvelope(r)
+	if err != nil {
+		return &Reader{nil, 0, err}
+	}
+	if !ok {
+		if _, err := br.Peek(1); errors.Is(err, io.EOF) {
+			return bytes.NewReader(nil)
+		}
+	}
+	if !ok || env.Algorithm != AlgorithmAge {
+		return &Reader{nil, 0, fmt.Errorf("decryption failed: object is not encrypted with %s", AlgorithmAge)}
+	}
+	_, _ = br.Discard(len(header))
+
+	dr, err := age.Decrypt(br, a.identities...)
+	if err != nil {
+		return &Reader{nil, 0, fmt.Errorf("decryption failed: %w", err)}
+	}
+
+	// the name precedes the data, so it is checked before any data is returned
+	var l [2]byte
+	if _, err := io.ReadFull(dr, l[:]); err != nil {
+		return &Reader{nil, 0, errors.New("decryption failed: missing object name")}
+	}
+	stored := make([]byte, binary.BigEndian.Uint16(l[:]))
+	if _, err := io.ReadFull(dr, stored); err != nil {
+		return &Reader{nil, 0, errors.New("decryption failed: missing object name")}
+	}
+	if string(stored) != name {
+		return &Reader{nil, 0, errors.New("decryption failed: object was moved")}
+	}
+	return &ageReader{dr}
+}
+
+// ageReader prefixes the errors of the age decryption reader.
+type ageReader struct {
+	r io.Reader
+}
+
+func (a *ageReader) Read(p []byte) (int, error) {
+	n, err := a.r.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) {
+		err = fmt.Errorf("decryption failed: %w", err)
+	}
+	return n, err
+}
+
+func (a *AgeIO) ByteReader(name string, msg []byte) Reader {
+	if len(a.recipients) == 0 {
+		return Reader{nil, 0, errors.New("encryption failed: no age recipient configured")}
+	}
+	if len(name) > 0xffff {
+		return Reader{nil, 0, errors.New("encryption failed: object name is too long")}
+	}
+
+	out := bytes.NewBuffer(AppendEnvelope(nil, AlgorithmAge, ""))
+	w, err := age.Encrypt(out, a.recipients...)
+	if err != nil {
+		return Reader{nil, 0, err}
+	}
+	if _, err := w.Write(binary.BigEndian.AppendUint16(nil, uint16(len(name)))); err != nil {
+		return Reader{nil, 0, err}
+	}
+	if _, err := io.WriteString(w, name); err != nil {
+		return Reader{nil, 0, err}
+	}
+	if _, err := w.Write(msg); err != nil {
+		return Reader{nil, 0, err}
+	}
+	if err := w.Close(); err != nil {
+		return Reader{nil, 0, err}
+	}
+
+	return Reader{bytes.NewReader(out.Bytes()), int64(out.Len()), nil}
+}
+
+// wipe drops the identities. Their keys cannot be overwritten, as the age
+// package does not expose them.
+func (a *AgeIO) wipe() {
+	a.identities = nil
+}
+
+var _ IO = (*AgeIO)(nil)
@@ -0,0 +1,190 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	s3sdk "github.com/aws/aws-sdk-go-v2/service/s3"
+	"go.uber.org/zap"
+)
+
+// Sinks of S3.Audit.
+const (
+	AuditLog    = "log"
+	AuditBucket = "bucket"
+)
+
+// DefaultAuditPrefix is the prefix of audit events stored in the bucket.
+const DefaultAuditPrefix = "audit"
+
+// Operations recorded in audit events, besides those of the metrics.
+const opRewrite = "rewrite"
+
+// AuditEvent records a change of an object in the bucket.
+type AuditEvent struct {
+	Time      time.Time `json:"time"`
+	Instance  string    `json:"instance"`
+	Operation string    `json:"operation"`
+	Bucket    string    `json:"bucket"`
+	Key       string    `json:"key"`
+	Size      int       `json:"size,omitempty"`
+	ETag      string    `json:"etag,omitempty"`
+	VersionID string    `json:"version_id,omitempty"`
+
+	// Error is set if the change failed, in which case it may or may not have been applied.
+	Error string `json:"error,omitempty"`
+}
+
+// auditSink records audit events.
+type auditSink interface {
+	record(ctx context.Context, event AuditEvent) error
+}
+
+func (s3 *S3) setupAudit() error {
+	if s3.Audit == "" {
+		if s3.AuditPrefix != "" || s3.AuditInstance != "" {
+			return errors.New("'audit_prefix' and 'audit_instance' require 'audit'")
+		}
+		return nil
+	}
+
+	if s3.AuditInstance == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("failed to determine audit instance: %w", err)
+		}
+		s3.AuditInstance = hostname
+	}
+
+	switch s3.Audit {
+	case AuditLog:
+		if s3.AuditPrefix != "" {
+			return fmt.Errorf("'audit_prefix' requires 'audit %s'", AuditBucket)
+		}
+		s3.auditSink = &logAuditSink{s3.Logger.Named("audit")}
+	case AuditBucket:
+		if s3.AuditPrefix == "" {
+			s3.AuditPrefix = DefaultAuditPrefix
+		}
+		prefix := strings.Trim(s3.AuditPrefix, "/") + "/"
+		storage := strings.Trim(s3.Prefix, "/") + "/"
+		// certmagic lists and loads every object below the storage prefix
+		if storage == "/" || strings.HasPrefix(prefix, storage) || strings.HasPrefix(storage, prefix) {
+			return fmt.Errorf("audit_prefix %q must not overlap with prefix %q", s3.AuditPrefix, s3.Prefix)
+		}
+		s3.auditSink = &bucketAuditSink{s3}
+	default:
+		return fmt.Errorf("unknown audit sink: %s", s3.Audit)
+	}
+	return nil
+}
+
+// audit records a change of objName. Failures to record it are logged, as
+// the change was already made.
+func (s3 *S3) audit(ctx context.Context, operation, objName string, size int, etag, versionID *string, err error) {
+	if s3.auditSink == nil {
+		return
+	}
+
+	event := AuditEvent{
+		Time:      time.Now().UTC(),
+		Instance:  s3.AuditInstance,
+		Operation: operation,
+		Bucket:    s3.Bucket,
+		Key:       objName,
+		Size:      size,
+		ETag:      aws.ToString(etag),
+		VersionID: aws.ToString(versionID),
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+
+	// the event is recorded even if the caller gives up right after the change,
+	// and counts as a write even when recording a lock
+	ctx = context.WithValue(context.WithoutCancel(ctx), budgetKey{}, BudgetWrite)
+	if err := s3.auditSink.record(ctx, event); err != nil {
+		s3.Logger.Error("failed to record audit event",
+			zap.String("operation", operation),
+			zap.String("key", objName),
+			zap.Error(err),
+		)
+	}
+}
+
+// logAuditSink writes audit events to a dedicated logger, which can be
+// routed with the name "audit" of the storage logger.
+type logAuditSink struct {
+	logger *zap.Logger
+}
+
+func (l *logAuditSink) record(_ context.Context, event AuditEvent) error {
+	fields := []zap.Field{
+		zap.Time("time", event.Time),
+		zap.String("instance", event.Instance),
+		zap.String("operation", event.Operation),
+		zap.String("bucket", event.Bucket),
+		zap.String("key", event.Key),
+	}
+	if event.Size > 0 {
+		fields = append(fields, zap.Int("size", event.Size))
+	}
+	if event.ETag != "" {
+		fields = append(fields, zap.String("etag", event.ETag))
+	}
+	if event.VersionID != "" {
+		fields = append(fields, zap.String("version_id", event.VersionID))
+	}
+	if event.Error != "" {
+		fields = append(fields, zap.String("error", event.Error))
+	}
+	l.logger.Info("audit", fields...)
+	return nil
+}
+
+// bucketAuditSink stores every audit event as a JSON object of its own below
+// the audit prefix, which is only ever created and never replaced:
+//
+//	<audit prefix>/<yyyy>/<mm>/<dd>/<time>-<instance>-<random>.json
+type bucketAuditSink struct {
+	s3 *S3
+}
+
+func (b *bucketAuditSink) record(ctx context.Context, event AuditEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	input := &s3sdk.PutObjectInput{
+		Bucket:        aws.String(b.s3.Bucket),
+		Key:           aws.String(b.objName(event)),
+		Body:          bytes.NewReader(data),
+		ContentLength: aws.Int64(int64(len(data))),
+		ContentType:   aws.String("application/json"),
+		IfNoneMatch:   aws.String("*"),
+	}
+	b.s3.applySSEPut(input)
+
+	_, err = b.s3.Client.PutObject(ctx, input)
+	return err
+}
+
+// objName returns a unique name for event, which sorts by time.
+func (b *bucketAuditSink) objName(event AuditEvent) string {
+	var suffix [4]byte
+	_, _ = rand.Read(suffix[:])
+	instance := strings.ReplaceAll(event.Instance, "/", "_")
+	name := fmt.Sprintf("%s-%s-%s.json", event.Time.Format("20060102T150405.000000000Z"), instance, hex.EncodeToString(suffix[:]))
+	return path.Join(strings.Trim(b.s3.AuditPrefix, "/"), event.Time.Format("2006/01/02"), name)
+}
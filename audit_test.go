@@ -0,0 +1,127 @@
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// auditEvents returns the audit events stored in fake, sorted by name.
+func auditEvents(t *testing.T, fake *fakeS3, prefix string) []AuditEvent {
+	t.Helper()
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	var names []string
+	for name := range fake.objects {
+		if strings.HasPrefix(name, prefix+"/") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	events := make([]AuditEvent, len(names))
+	for i, name := range names {
+		if err := json.Unmarshal(fake.objects[name].data, &events[i]); err != nil {
+			t.Fatalf("invalid audit event %s: %v", name, err)
+		}
+	}
+	return events
+}
+
+func TestS3_Audit(t *testing.T) {
+	ctx := context.Background()
+	cert := []byte("-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n")
+
+	t.Run("bucket", func(t *testing.T) {
+		s3, fake := newTestS3(t)
+		s3.Audit = AuditBucket
+		s3.AuditInstance = "node/1"
+		assertNoError(t, s3.setupAudit(), "setupAudit()")
+
+		assertNoError(t, s3.Lock(ctx, "site.crt"), "Lock()")
+		assertNoError(t, s3.Store(ctx, "site.crt", cert), "Store()")
+		assertNoError(t, s3.Delete(ctx, "site.crt"), "Delete()")
+		assertNoError(t, s3.Unlock(ctx, "site.crt"), "Unlock()")
+		_, _ = s3.Load(ctx, "site.crt")
+
+		events := auditEvents(t, fake, DefaultAuditPrefix)
+		want := []struct{ operation, key string }{
+			{opLock, s3.objLockName("site.crt")},
+			{opStore, s3.objName("site.crt")},
+			{opDelete, s3.objName("site.crt")},
+			{opUnlock, s3.objLockName("site.crt")},
+		}
+		if len(events) != len(want) {
+			t.Fatalf("recorded %d audit events, want %d: %+v", len(events), len(want), events)
+		}
+		for i, event := range events {
+			if event.Operation != want[i].operation || event.Key != want[i].key {
+				t.Errorf("event %d = %s %s, want %s %s", i, event.Operation, event.Key, want[i].operation, want[i].key)
+			}
+			if event.Instance != "node/1" || event.Bucket != testBucket || event.Time.IsZero() || event.Error != "" {
+				t.Errorf("event %d = %+v", i, event)
+			}
+		}
+		if store := events[1]; store.Size != len(cert) || store.ETag == "" {
+			t.Errorf("store event size = %d, etag = %q", store.Size, store.ETag)
+		}
+	})
+
+	t.Run("failures", func(t *testing.T) {
+		s3, fake := newTestS3(t)
+		s3.Audit = AuditLog
+		core, logs := observer.New(zap.InfoLevel)
+		s3.Logger = zap.New(core)
+		assertNoError(t, s3.setupAudit(), "setupAudit()")
+
+		fake.setFailing(true)
+		assertError(t, s3.Store(ctx, "site.crt", cert), "failed to store key", "Store()")
+
+		entries := logs.FilterMessage("audit").All()
+		if len(entries) != 1 {
+			t.Fatalf("logged %d audit events, want 1", len(entries))
+		}
+		fields := entries[0].ContextMap()
+		if fields["operation"] != opStore || fields["key"] != s3.objName("site.crt") || fields["error"] == nil {
+			t.Errorf("audit event = %v", fields)
+		}
+	})
+}
+
+func TestS3_setupAudit(t *testing.T) {
+	tests := []struct {
+		name    string
+		s3      S3
+		wantErr string
+	}{
+		{name: "disabled", s3: S3{}},
+		{name: "log", s3: S3{Audit: AuditLog}},
+		{name: "bucket", s3: S3{Audit: AuditBucket, Prefix: "acme"}},
+		{name: "unknown", s3: S3{Audit: "syslog"}, wantErr: "unknown audit sink"},
+		{name: "prefix without audit", s3: S3{AuditPrefix: "audit"}, wantErr: "require 'audit'"},
+		{name: "prefix with log", s3: S3{Audit: AuditLog, AuditPrefix: "audit"}, wantErr: "requires 'audit bucket'"},
+		{name: "inside prefix", s3: S3{Audit: AuditBucket, Prefix: "acme", AuditPrefix: "acme/audit"}, wantErr: "must not overlap"},
+		{name: "empty prefix", s3: S3{Audit: AuditBucket}, wantErr: "must not overlap"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s3 := tt.s3
+			s3.Logger = zap.NewNop()
+			err := s3.setupAudit()
+			if tt.wantErr != "" {
+				assertError(t, err, tt.wantErr, "setupAudit()")
+				return
+			}
+			assertNoError(t, err, "setupAudit()")
+			if (s3.Audit != "") != (s3.auditSink != nil) || (s3.Audit != "" && s3.AuditInstance == "") {
+				t.Errorf("sink = %T, instance = %q", s3.auditSink, s3.AuditInstance)
+			}
+		})
+	}
+}
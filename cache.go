@@ -0,0 +1,193 @@
+package s3
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"errors"
+	"io/fs"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"go.uber.org/zap"
+)
+
+// ReadCacheTTL is how long cached values are returned without revalidating
+// them with S3 by default.
+var ReadCacheTTL = time.Minute
+
+// errNotModified is returned by load if the object still has the given ETag.
+var errNotModified = errors.New("object not modified")
+
+// readCache is a bounded LRU cache of loaded values by object name. Entries
+// older than the TTL are revalidated with their ETag.
+type readCache struct {
+	size int
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+
+	// generation is incremented by every invalidation, so that values loaded
+	// concurrently with a Store or Delete are not cached
+	generation uint64
+
+	// purged is set once the module is unloaded, after which nothing is cached
+	purged bool
+}
+
+type cacheEntry struct {
+	objName   string
+	data      []byte
+	etag      string
+	validated time.Time
+}
+
+func newReadCache(size int, ttl time.Duration) *readCache {
+	return &readCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+func (s3 *S3) setupReadCache() error {
+	if s3.ReadCacheSize < 0 {
+		return errors.New("'read_cache_size' must not be negative")
+	}
+	if s3.ReadCacheSize == 0 {
+		return nil
+	}
+
+	ttl := time.Duration(s3.ReadCacheTTL)
+	if ttl == 0 {
+		ttl = ReadCacheTTL
+	}
+	s3.cache = newReadCache(s3.ReadCacheSize, ttl)
+	return nil
+}
+
+// loadCached loads key using the read cache. Fresh values are returned from
+// the cache, others are only read again if their ETag changed.
+func (s3 *S3) loadCached(ctx context.Context, key string) (loadedObject, error) {
+	objName := s3.objName(key)
+	generation := s3.cache.begin()
+
+	cached, etag, fresh, ok := s3.cache.get(objName)
+	if ok && fresh {
+		return loadedObject{data: cached}, nil
+	}
+
+	var ifNoneMatch *string
+	if ok {
+		ifNoneMatch = aws.String(etag)
+	}
+	obj, err := s3.load(ctx, key, ifNoneMatch)
+	if errors.Is(err, errNotModified) {
+		s3.cache.revalidated(objName, etag)
+		if s3.fallback != nil {
+			s3.fallback.touch(objName)
+		}
+		s3.Logger.Debug("revalidated cached object", zap.String("key", objName))
+		return loadedObject{data: cached}, nil
+	}
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			s3.cache.invalidate(objName)
+		}
+		return loadedObject{}, err
+	}
+
+	// unencrypted objects are replaced if CleartextRewrite is enabled, so they are not cached
+	if !obj.cleartext {
+		s3.cache.put(objName, obj.data, aws.ToString(obj.etag), generation)
+	}
+	return obj, nil
+}
+
+// get returns a copy of the cached value of objName, and whether it is fresh.
+// Values that are not fresh must be revalidated using their ETag.
+func (c *readCache) get(objName string) (data []byte, etag string, fresh bool, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[objName]
+	if !ok {
+		return nil, "", false, false
+	}
+	c.lru.MoveToFront(el)
+	entry := el.Value.(*cacheEntry)
+	return bytes.Clone(entry.data), entry.etag, time.Since(entry.validated) < c.ttl, true
+}
+
+// begin returns the generation to pass to put for a value that is about to be loaded.
+func (c *readCache) begin() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// put caches data with etag, unless the cache was invalidated since begin
+// returned generation.
+func (c *readCache) put(objName string, data []byte, etag string, generation uint64) {
+	if etag == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation || c.purged {
+		return
+	}
+
+	entry := &cacheEntry{objName: objName, data: bytes.Clone(data), etag: etag, validated: time.Now()}
+	if el, ok := c.entries[objName]; ok {
+		el.Value = entry
+		c.lru.MoveToFront(el)
+		return
+	}
+
+	c.entries[objName] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).objName)
+	}
+}
+
+// revalidated marks the cached value of objName as fresh, if it still has etag.
+func (c *readCache) revalidated(objName, etag string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[objName]; ok && el.Value.(*cacheEntry).etag == etag {
+		el.Value.(*cacheEntry).validated = time.Now()
+	}
+}
+
+// invalidate removes the cached value of objName.
+func (c *readCache) invalidate(objName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	if el, ok := c.entries[objName]; ok {
+		c.lru.Remove(el)
+		delete(c.entries, objName)
+	}
+}
+
+// purge removes all cached values, overwriting them as they may hold keys,
+// and stops caching values loaded later on.
+func (c *readCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.purged = true
+	for _, el := range c.entries {
+		clear(el.Value.(*cacheEntry).data)
+	}
+	clear(c.entries)
+	c.lru.Init()
+}
@@ -0,0 +1,118 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestS3_ReadCache(t *testing.T) {
+	ctx := context.Background()
+	cert := []byte("-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n")
+
+	newStorage := func(t *testing.T, size int, ttl time.Duration) (*S3, *fakeS3) {
+		s3, fake := newTestS3(t)
+		s3.cache = newReadCache(size, ttl)
+		assertNoError(t, s3.Store(ctx, "site.crt", cert), "Store()")
+		return s3, fake
+	}
+
+	load := func(t *testing.T, s3 *S3, key string, want []byte) {
+		t.Helper()
+		buf, err := s3.Load(ctx, key)
+		assertNoError(t, err, "Load("+key+")")
+		if !bytes.Equal(buf, want) {
+			t.Errorf("Load(%s) = %q, want %q", key, buf, want)
+		}
+	}
+
+	t.Run("fresh", func(t *testing.T) {
+		s3, fake := newStorage(t, 10, time.Hour)
+		load(t, s3, "site.crt", cert)
+		buf, err := s3.Load(ctx, "site.crt")
+		assertNoError(t, err, "Load()")
+		if n := fake.count(http.MethodGet); n != 1 {
+			t.Errorf("sent %d GET requests, want 1", n)
+		}
+
+		// callers must not be able to modify the cache
+		buf[0] = 'x'
+		load(t, s3, "site.crt", cert)
+	})
+
+	t.Run("revalidate", func(t *testing.T) {
+		s3, fake := newStorage(t, 10, -1)
+		load(t, s3, "site.crt", cert)
+		load(t, s3, "site.crt", cert)
+		if n := fake.count(http.MethodGet); n != 2 {
+			t.Errorf("sent %d GET requests, want 2", n)
+		}
+
+		// changed by another instance
+		other := []byte("renewed")
+		fake.put(s3.objName("site.crt"), sealed(t, s3, "site.crt", other))
+		load(t, s3, "site.crt", other)
+	})
+
+	t.Run("store and delete", func(t *testing.T) {
+		s3, _ := newStorage(t, 10, time.Hour)
+		load(t, s3, "site.crt", cert)
+
+		renewed := []byte("renewed")
+		assertNoError(t, s3.Store(ctx, "site.crt", renewed), "Store()")
+		load(t, s3, "site.crt", renewed)
+
+		assertNoError(t, s3.Delete(ctx, "site.crt"), "Delete()")
+		if _, err := s3.Load(ctx, "site.crt"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Load() after Delete() error = %v, want fs.ErrNotExist", err)
+		}
+	})
+
+	t.Run("evict", func(t *testing.T) {
+		s3, fake := newStorage(t, 1, time.Hour)
+		assertNoError(t, s3.Store(ctx, "other.crt", cert), "Store()")
+		load(t, s3, "site.crt", cert)
+		load(t, s3, "other.crt", cert)
+		load(t, s3, "site.crt", cert)
+		if n := fake.count(http.MethodGet); n != 3 {
+			t.Errorf("sent %d GET requests, want 3", n)
+		}
+	})
+}
+
+func TestReadCache_put(t *testing.T) {
+	c := newReadCache(2, time.Hour)
+
+	// values loaded before an invalidation are not cached
+	generation := c.begin()
+	c.invalidate("a")
+	c.put("a", []byte("old"), `"1"`, generation)
+	if _, _, _, ok := c.get("a"); ok {
+		t.Error("cached value loaded before invalidation")
+	}
+
+	c.put("a", []byte("a"), `"1"`, c.begin())
+	c.put("b", []byte("b"), `"2"`, c.begin())
+	c.get("a")
+	c.put("c", []byte("c"), `"3"`, c.begin())
+	if _, _, _, ok := c.get("b"); ok {
+		t.Error("least recently used value was not evicted")
+	}
+	if data, etag, fresh, ok := c.get("a"); !ok || !fresh || etag != `"1"` || string(data) != "a" {
+		t.Errorf("get(a) = %q, %s, %v, %v", data, etag, fresh, ok)
+	}
+}
+
+// sealed returns data as stored by s3.
+func sealed(t *testing.T, s3 *S3, key string, data []byte) []byte {
+	t.Helper()
+	r := s3.iowrap.ByteReader(s3.objName(key), data)
+	var buf bytes.Buffer
+	_, err := buf.ReadFrom(&r)
+	assertNoError(t, err, "ByteReader()")
+	return buf.Bytes()
+}
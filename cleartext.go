@@ -0,0 +1,140 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+)
+
+// Modes of S3.CleartextReads.
+const (
+	CleartextReadsDetect   = "detect"
+	CleartextReadsFallback = "fallback"
+)
+
+func (s3 *S3) setupCleartextReads() error {
+	switch s3.CleartextReads {
+	case "":
+		if s3.CleartextRewrite {
+			return errors.New("'cleartext_rewrite' requires 'cleartext_reads'")
+		}
+		return nil
+	case CleartextReadsDetect, CleartextReadsFallback:
+	default:
+		return fmt.Errorf("unknown cleartext_reads mode: %s", s3.CleartextReads)
+	}
+
+	// unencrypted objects may have been compressed
+	cleartext, err := NewCompressIO(&CleartextIO{}, AlgorithmNone, 0)
+	if err != nil {
+		return err
+	}
+	s3.cleartext = cleartext
+
+	s3.Logger.Warn("Loading unencrypted objects is enabled, disable 'cleartext_reads' once all objects are encrypted",
+		zap.String("mode", s3.CleartextReads),
+		zap.Bool("rewrite", s3.CleartextRewrite),
+	)
+	return nil
+}
+
+// readObject unwraps the object read from body with iowrap. With CleartextReads,
+// unencrypted objects that fail to decrypt are returned as they are, and
+// cleartext is set. unverified is set as well if the object was only accepted
+// because it failed to decrypt, in which case it may be ciphertext.
+func (s3 *S3) readObject(objName string, body io.Reader) (data []byte, cleartext, unverified bool, err error) {
+	if s3.cleartext == nil {
+		data, err = io.ReadAll(s3.iowrap.WrapReader(objName, body))
+		return data, false, false, err
+	}
+
+	// the object is buffered, so that it can be read again
+	raw, err := io.ReadAll(body)
+	if err != nil {
+		return nil, false, false, err
+	}
+
+	data, err = io.ReadAll(s3.iowrap.WrapReader(objName, bytes.NewReader(raw)))
+	if err == nil {
+		return data, false, false, nil
+	}
+	if data, verified, ok := s3.readCleartext(objName, raw); ok {
+		return data, true, !verified, nil
+	}
+	return nil, false, false, err
+}
+
+// readCleartext returns the data of raw if it is an unencrypted object, and
+// whether it is known to be unencrypted: it has an envelope without encryption
+// or is text, rather than only failing to decrypt.
+func (s3 *S3) readCleartext(objName string, raw []byte) (data []byte, verified, ok bool) {
+	env, _, hasEnvelope := ParseEnvelope(raw)
+	if hasEnvelope && env.Algorithm != AlgorithmNone {
+		return nil, false, false
+	}
+	verified = hasEnvelope || isText(raw)
+	if !verified && s3.CleartextReads == CleartextReadsDetect {
+		return nil, false, false
+	}
+
+	data, err := io.ReadAll(s3.cleartext.WrapReader(objName, bytes.NewReader(raw)))
+	if err != nil {
+		return nil, false, false
+	}
+	return data, verified, true
+}
+
+// loadedCleartext logs and counts that the unencrypted object key was loaded, and
+// stores it again with the current settings if CleartextRewrite is enabled.
+// The object is only replaced if it was not changed in the meantime, and if it
+// is known to be unencrypted, as it could be ciphertext that merely failed to
+// decrypt, e.g. under a key that is no longer configured.
+func (s3 *S3) loadedCleartext(ctx context.Context, key string, obj loadedObject) {
+	objName := s3.objName(key)
+	s3.metrics.observeCleartext(false)
+	s3.Logger.Warn("loaded unencrypted object",
+		zap.String("key", objName),
+		zap.Bool("unverified", obj.unverified),
+	)
+
+	if !s3.CleartextRewrite {
+		return
+	}
+	if obj.unverified {
+		s3.Logger.Warn("not rewriting object that failed to decrypt and is not known to be unencrypted",
+			zap.String("key", objName),
+		)
+		return
+	}
+	out, err := s3.putObject(ctx, objName, obj.data, obj.etag)
+	s3.audit(ctx, opRewrite, objName, len(obj.data), out.ETag, out.VersionId, err)
+	if err != nil {
+		s3.Logger.Error("failed to rewrite unencrypted object",
+			zap.String("key", objName),
+			zap.Error(err),
+		)
+		return
+	}
+
+	s3.metrics.observeCleartext(true)
+	s3.Logger.Info("rewrote unencrypted object", zap.String("key", objName))
+}
+
+// isText reports whether buf is UTF-8 text without control characters, like
+// the PEM and JSON data stored by certmagic. Encrypted data almost never is.
+func isText(buf []byte) bool {
+	if !utf8.Valid(buf) {
+		return false
+	}
+	for _, c := range buf {
+		if c < 0x20 && c != '\n' && c != '\r' && c != '\t' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,147 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// cleartextCount returns how many unencrypted objects s3 counted with action.
+func cleartextCount(s3 *S3, action string) float64 {
+	return testutil.ToFloat64(s3.metrics.cleartext.WithLabelValues(action))
+}
+
+func TestS3_CleartextReads(t *testing.T) {
+	ctx := context.Background()
+	cert := []byte("-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n")
+	binary := []byte("\x00\x9f\x12 not text \xff")
+
+	newStorage := func(t *testing.T, mode string) (*S3, *fakeS3) {
+		s3, fake := newTestS3(t)
+		var err error
+		s3.metrics, err = newStorageMetrics(prometheus.NewRegistry(), t.Name())
+		assertNoError(t, err, "newStorageMetrics()")
+		assertNoError(t, s3.Store(ctx, "enveloped.crt", cert), "Store()")
+		fake.put(s3.objName("headerless.crt"), cert)
+		fake.put(s3.objName("binary.key"), binary)
+
+		s3.iowrap = NewSecretBoxIO(testKey32)
+		s3.CleartextReads = mode
+		assertNoError(t, s3.setupCleartextReads(), "setupCleartextReads()")
+		return s3, fake
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		s3, _ := newStorage(t, "")
+		for _, key := range []string{"enveloped.crt", "headerless.crt"} {
+			_, err := s3.Load(ctx, key)
+			assertError(t, err, "failed to read/decrypt", "Load("+key+")")
+		}
+	})
+
+	tests := []struct {
+		mode      string
+		key       string
+		want      []byte
+		wantError bool
+	}{
+		{mode: CleartextReadsDetect, key: "enveloped.crt", want: cert},
+		{mode: CleartextReadsDetect, key: "headerless.crt", want: cert},
+		{mode: CleartextReadsDetect, key: "binary.key", wantError: true},
+		{mode: CleartextReadsFallback, key: "binary.key", want: binary},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode+"/"+tt.key, func(t *testing.T) {
+			s3, _ := newStorage(t, tt.mode)
+			buf, err := s3.Load(ctx, tt.key)
+			if tt.wantError {
+				assertError(t, err, "failed to read/decrypt", "Load()")
+				return
+			}
+			assertNoError(t, err, "Load()")
+			if !bytes.Equal(buf, tt.want) {
+				t.Errorf("Load() = %q, want %q", buf, tt.want)
+			}
+			if n := cleartextCount(s3, "loaded"); n != 1 {
+				t.Errorf("loaded %v unencrypted objects, want 1", n)
+			}
+		})
+	}
+
+	t.Run("encrypted objects", func(t *testing.T) {
+		s3, _ := newStorage(t, CleartextReadsFallback)
+		assertNoError(t, s3.Store(ctx, "encrypted.crt", cert), "Store()")
+		buf, err := s3.Load(ctx, "encrypted.crt")
+		assertNoError(t, err, "Load()")
+		if !bytes.Equal(buf, cert) || cleartextCount(s3, "loaded") != 0 {
+			t.Errorf("Load() = %q, counted as unencrypted: %v", buf, cleartextCount(s3, "loaded") != 0)
+		}
+	})
+
+	t.Run("rewrite", func(t *testing.T) {
+		s3, fake := newStorage(t, CleartextReadsDetect)
+		s3.CleartextRewrite = true
+
+		for _, key := range []string{"enveloped.crt", "headerless.crt"} {
+			_, err := s3.Load(ctx, key)
+			assertNoError(t, err, "Load()")
+			stored, _ := fake.get(s3.objName(key))
+			if env, _, ok := ParseEnvelope(stored); !ok || env.Algorithm != AlgorithmSecretBox {
+				t.Errorf("%s was not rewritten encrypted: %q", key, stored)
+			}
+		}
+		if n := cleartextCount(s3, "rewritten"); n != 2 {
+			t.Errorf("rewrote %v objects, want 2", n)
+		}
+
+		// an object changed since it was loaded is not replaced
+		fake.put(s3.objName("changed.crt"), []byte("newer"))
+		s3.loadedCleartext(ctx, "changed.crt", loadedObject{data: cert, etag: aws.String(`"stale"`), cleartext: true})
+		if stored, _ := fake.get(s3.objName("changed.crt")); string(stored) != "newer" {
+			t.Errorf("changed object was replaced with %q", stored)
+		}
+	})
+
+	t.Run("legacy ciphertext", func(t *testing.T) {
+		s3, fake := newTestS3(t)
+		var err error
+		s3.metrics, err = newStorageMetrics(prometheus.NewRegistry(), t.Name())
+		assertNoError(t, err, "newStorageMetrics()")
+
+		// a private key stored by SecretBoxIO before the envelope was introduced
+		r := NewSecretBoxIO(testKey32).ByteReader(testObjName, cert)
+		sealed, err := io.ReadAll(&r)
+		assertNoError(t, err, "encrypting")
+		_, headerless, _ := ParseEnvelope(sealed)
+		fake.put(s3.objName("site.key"), headerless)
+
+		s3.iowrap, err = NewXChaChaIO(testKey32)
+		assertNoError(t, err, "NewXChaChaIO()")
+		s3.CleartextReads = CleartextReadsFallback
+		s3.CleartextRewrite = true
+		assertNoError(t, s3.setupCleartextReads(), "setupCleartextReads()")
+
+		_, err = s3.Load(ctx, "site.key")
+		assertNoError(t, err, "Load()")
+		if stored, _ := fake.get(s3.objName("site.key")); !bytes.Equal(stored, headerless) {
+			t.Error("object that failed to decrypt was rewritten")
+		}
+		if n := cleartextCount(s3, "rewritten"); n != 0 {
+			t.Errorf("rewrote %v objects, want 0", n)
+		}
+	})
+
+	t.Run("migrate", func(t *testing.T) {
+		s3, _ := newStorage(t, CleartextReadsFallback)
+		summary, err := Migrate(ctx, s3, s3, MigrateOptions{DryRun: true})
+		assertNoError(t, err, "Migrate()")
+		if summary.Cleartext != 3 {
+			t.Errorf("Migrate() = %+v, want 3 unencrypted objects", summary)
+		}
+	})
+}
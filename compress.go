@@ -0,0 +1,202 @@
+package s3
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// DefaultCompressionMinSize is the size below which values are stored uncompressed.
+const DefaultCompressionMinSize = 512
+
+// maxDecompressedSize bounds the size of decompressed objects.
+const maxDecompressedSize = 64 << 20
+
+// ParseCompression returns the compression algorithm with the given name.
+func ParseCompression(name string) (Algorithm, error) {
+	switch name {
+	case "gzip":
+		return AlgorithmGzip, nil
+	case "zstd":
+		return AlgorithmZstd, nil
+	}
+	return AlgorithmNone, fmt.Errorf("unknown compression algorithm: %s", name)
+}
+
+// CompressIO compresses values before they are passed to another IO, which
+// usually encrypts them. Compressed values are stored behind an envelope of their
+// own inside the data of the wrapped IO, so that reading does not depend on the
+// current configuration: values without that envelope are returned unchanged.
+// Uncompressed values that start like an envelope get an envelope without
+// compression, so that they are not mistaken for compressed ones.
+type CompressIO struct {
+	next      IO
+	algorithm Algorithm
+	minSize   int
+
+	// zstdEncoder is created on first use, as only zstd needs it
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdErr     error
+}
+
+// NewCompressIO returns a CompressIO that stores values of at least minSize bytes
+// in next compressed with algorithm. With AlgorithmNone, values are only
+// decompressed when reading.
+func NewCompressIO(next IO, algorithm Algorithm, minSize int) (*CompressIO, error) {
+	if algorithm != AlgorithmNone && algorithm != AlgorithmGzip && algorithm != AlgorithmZstd {
+		return nil, fmt.Errorf("%s is not a compression algorithm", algorithm)
+	}
+
+	return &CompressIO{
+		next:      next,
+		algorithm: algorithm,
+		minSize:   minSize,
+	}, nil
+}
+
+func (c *CompressIO) WrapReader(name string, r io.Reader) io.Reader {
+	env, header, br, ok, err := peekEnvelope(c.next.WrapReader(name, r))
+	if err != nil {
+		return &Reader{nil, 0, err}
+	}
+	if !ok || (env.Algorithm != AlgorithmNone && env.Algorithm != AlgorithmGzip && env.Algorithm != AlgorithmZstd) {
+		return br
+	}
+	_, _ = br.Discard(len(header))
+	if env.Algorithm == AlgorithmNone {
+		return br
+	}
+
+	dr, err := c.decompressor(env.Algorithm, br)
+	if err != nil {
+		return &Reader{nil, 0, fmt.Errorf("decompression failed: %w", err)}
+	}
+	return &decompressReader{dr, maxDecompressedSize}
+}
+
+func (c *CompressIO) ByteReader(name string, msg []byte) Reader {
+	// larger values could not be decompressed by WrapReader
+	if c.algorithm == AlgorithmNone || len(msg) < c.minSize || len(msg) > maxDecompressedSize {
+		return c.next.ByteReader(name, frameUncompressed(msg))
+	}
+
+	compressed, err := c.compress(AppendEnvelope(nil, c.algorithm, ""), msg)
+	if err != nil {
+		return Reader{nil, 0, fmt.Errorf("compression failed: %w", err)}
+	}
+	if len(compressed) >= len(msg) {
+		// not worth it, e.g. for data that is already compressed
+		return c.next.ByteReader(name, frameUncompressed(msg))
+	}
+	return c.next.ByteReader(name, compressed)
+}
+
+// frameUncompressed prefixes msg with an envelope without compression if it
+// starts with the envelope magic bytes, and returns other values unchanged.
+func frameUncompressed(msg []byte) []byte {
+	if !bytes.HasPrefix(msg, []byte(EnvelopeMagic)) {
+		return msg
+	}
+	framed := AppendEnvelope(make([]byte, 0, envelopeLen("")+len(msg)), AlgorithmNone, "")
+	return append(framed, msg...)
+}
+
+func (c *CompressIO) wipe() {
+	if w, ok := c.next.(wiper); ok {
+		w.wipe()
+	}
+	// no encoder is created once the module is unloaded
+	c.zstdOnce.Do(func() { c.zstdErr = errKeyWiped })
+	if c.zstdEncoder != nil {
+		_ = c.zstdEncoder.Close()
+	}
+}
+
+// encoder returns the zstd encoder, creating it on first use.
+func (c *CompressIO) encoder() (*zstd.Encoder, error) {
+	c.zstdOnce.Do(func() {
+		c.zstdEncoder, c.zstdErr = zstd.NewWriter(nil)
+	})
+	return c.zstdEncoder, c.zstdErr
+}
+
+// compress appends msg compressed with the configured algorithm to dst.
+func (c *CompressIO) compress(dst, msg []byte) ([]byte, error) {
+	if c.algorithm == AlgorithmZstd {
+		enc, err := c.encoder()
+		if err != nil {
+			return nil, err
+		}
+		return enc.EncodeAll(msg, dst), nil
+	}
+
+	out := bytes.NewBuffer(dst)
+	w, err := gzip.NewWriterLevel(out, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// decompressor returns a reader decompressing r with algorithm.
+func (c *CompressIO) decompressor(algorithm Algorithm, r io.Reader) (io.Reader, error) {
+	if algorithm == AlgorithmZstd {
+		dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(maxDecompressedSize))
+		if err != nil {
+			return nil, err
+		}
+		return &zstdReader{dec: dec}, nil
+	}
+	return gzip.NewReader(r)
+}
+
+// zstdReader releases the decoder once the object has been read.
+type zstdReader struct {
+	dec *zstd.Decoder
+	err error
+}
+
+func (z *zstdReader) Read(p []byte) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	n, err := z.dec.Read(p)
+	if err != nil {
+		z.dec.Close()
+		z.err = err
+	}
+	return n, err
+}
+
+// decompressReader reports errors of the decompressor r, and fails once more
+// than n bytes are read.
+type decompressReader struct {
+	r io.Reader
+	n int64
+}
+
+func (d *decompressReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	d.n -= int64(n)
+	if d.n < 0 {
+		return n, fmt.Errorf("decompression failed: object exceeds %d bytes", maxDecompressedSize)
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		return n, fmt.Errorf("decompression failed: %w", err)
+	}
+	return n, err
+}
+
+var _ IO = (*CompressIO)(nil)
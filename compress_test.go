@@ -0,0 +1,161 @@
+package s3
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCompressIO(t *testing.T) {
+	chain := []byte(strings.Repeat("-----BEGIN CERTIFICATE-----\nMIIB...\n-----END CERTIFICATE-----\n", 20))
+
+	for _, algorithm := range []Algorithm{AlgorithmGzip, AlgorithmZstd} {
+		t.Run(algorithm.String(), func(t *testing.T) {
+			sb := NewSecretBoxIO(testKey32)
+			c, err := NewCompressIO(sb, algorithm, 64)
+			assertNoError(t, err, "NewCompressIO()")
+
+			r := c.ByteReader(testObjName, chain)
+			stored, err := io.ReadAll(&r)
+			assertNoError(t, err, "storing")
+			if len(stored) >= len(chain) {
+				t.Errorf("stored %d bytes, want less than %d", len(stored), len(chain))
+			}
+
+			inner, err := io.ReadAll(sb.WrapReader(testObjName, bytes.NewReader(stored)))
+			assertNoError(t, err, "decrypting")
+			if env, _, ok := ParseEnvelope(inner); !ok || env.Algorithm != algorithm {
+				t.Errorf("ParseEnvelope() = %+v, %v, want %s envelope", env, ok, algorithm)
+			}
+
+			// reading does not depend on the configured compression
+			plain, err := NewCompressIO(sb, AlgorithmNone, 0)
+			assertNoError(t, err, "NewCompressIO()")
+			buf, err := io.ReadAll(plain.WrapReader(testObjName, bytes.NewReader(stored)))
+			assertNoError(t, err, "loading")
+			if !bytes.Equal(buf, chain) {
+				t.Errorf("loaded %q, want %q", buf, chain)
+			}
+		})
+	}
+}
+
+func TestCompressIO_Uncompressed(t *testing.T) {
+	c, err := NewCompressIO(&CleartextIO{}, AlgorithmZstd, 16)
+	assertNoError(t, err, "NewCompressIO()")
+
+	tests := []struct {
+		name string
+		msg  []byte
+	}{
+		{name: "below minimum size", msg: []byte("short value")},
+		{name: "incompressible", msg: []byte("\x8f\x12\x55\xe0\x3b\xc7\x01\x9a\x7e\x44\xd2\x6b\xf0\x18\xa3\x5c")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := c.ByteReader(testObjName, tt.msg)
+			stored, err := io.ReadAll(&r)
+			assertNoError(t, err, "storing")
+			if want := append(AppendEnvelope(nil, AlgorithmNone, ""), tt.msg...); !bytes.Equal(stored, want) {
+				t.Errorf("stored %q, want %q", stored, want)
+			}
+
+			buf, err := io.ReadAll(c.WrapReader(testObjName, bytes.NewReader(stored)))
+			assertNoError(t, err, "loading")
+			if !bytes.Equal(buf, tt.msg) {
+				t.Errorf("loaded %q, want %q", buf, tt.msg)
+			}
+		})
+	}
+}
+
+func TestCompressIO_EnvelopeLikeValues(t *testing.T) {
+	values := [][]byte{
+		append(AppendEnvelope(nil, AlgorithmGzip, ""), "user data"...),
+		append(AppendEnvelope(nil, AlgorithmZstd, ""), "user data"...),
+		append(AppendEnvelope(nil, AlgorithmNone, ""), "user data"...),
+		[]byte(EnvelopeMagic),
+	}
+	for _, algorithm := range []Algorithm{AlgorithmNone, AlgorithmGzip} {
+		c, err := NewCompressIO(&CleartextIO{}, algorithm, 512)
+		assertNoError(t, err, "NewCompressIO()")
+		for _, msg := range values {
+			r := c.ByteReader(testObjName, msg)
+			stored, err := io.ReadAll(&r)
+			assertNoError(t, err, "storing")
+			buf, err := io.ReadAll(c.WrapReader(testObjName, bytes.NewReader(stored)))
+			assertNoError(t, err, "loading")
+			if !bytes.Equal(buf, msg) {
+				t.Errorf("%s: loaded %q, want %q", algorithm, buf, msg)
+			}
+		}
+	}
+}
+
+func TestCompressIO_Corrupted(t *testing.T) {
+	c, err := NewCompressIO(&CleartextIO{}, AlgorithmGzip, 0)
+	assertNoError(t, err, "NewCompressIO()")
+
+	stored := AppendEnvelope(nil, AlgorithmNone, "")
+	stored = AppendEnvelope(stored, AlgorithmGzip, "")
+	stored = append(stored, "not gzip"...)
+	_, err = io.ReadAll(c.WrapReader(testObjName, bytes.NewReader(stored)))
+	assertError(t, err, "decompression failed", "loading corrupted object")
+}
+
+func TestParseCompression(t *testing.T) {
+	if _, err := ParseCompression("brotli"); err == nil {
+		t.Error("ParseCompression(brotli) should fail")
+	}
+	if _, err := ParseAlgorithm("gzip"); err == nil {
+		t.Error("ParseAlgorithm(gzip) should fail, gzip is not an encryption algorithm")
+	}
+	if _, err := NewCompressIO(&CleartextIO{}, AlgorithmSecretBox, 0); err == nil {
+		t.Error("NewCompressIO(secretbox) should fail")
+	}
+}
+
+func TestCompressIO_zstdEncoder(t *testing.T) {
+	chain := []byte(strings.Repeat("-----BEGIN CERTIFICATE-----\n", 40))
+	for _, algorithm := range []Algorithm{AlgorithmGzip, AlgorithmZstd} {
+		c, err := NewCompressIO(&CleartextIO{}, algorithm, 1)
+		assertNoError(t, err, "NewCompressIO()")
+		if c.zstdEncoder != nil {
+			t.Errorf("NewCompressIO(%s) created a zstd encoder", algorithm)
+		}
+		r := c.ByteReader(testObjName, chain)
+		_, err = io.ReadAll(&r)
+		assertNoError(t, err, "storing")
+		if (c.zstdEncoder != nil) != (algorithm == AlgorithmZstd) {
+			t.Errorf("%s created a zstd encoder: %v", algorithm, c.zstdEncoder != nil)
+		}
+		c.wipe()
+	}
+}
+
+func TestS3_setupCompression(t *testing.T) {
+	s3 := &S3{iowrap: &CleartextIO{}, Compression: "gzip", CompressionMinSize: -1}
+	assertError(t, s3.setupCompression(), "must be at least 1", "setupCompression()")
+
+	s3 = &S3{iowrap: &CleartextIO{}, Compression: "gzip"}
+	assertNoError(t, s3.setupCompression(), "setupCompression()")
+	if c := s3.iowrap.(*CompressIO); c.minSize != DefaultCompressionMinSize {
+		t.Errorf("minSize = %d, want %d", c.minSize, DefaultCompressionMinSize)
+	}
+}
+
+func TestCompressIO_large(t *testing.T) {
+	c, err := NewCompressIO(&CleartextIO{}, AlgorithmZstd, 1)
+	assertNoError(t, err, "NewCompressIO()")
+
+	msg := make([]byte, maxDecompressedSize+1)
+	r := c.ByteReader(testObjName, msg)
+	stored, err := io.ReadAll(&r)
+	assertNoError(t, err, "storing")
+	buf, err := io.ReadAll(c.WrapReader(testObjName, bytes.NewReader(stored)))
+	assertNoError(t, err, "loading")
+	if !bytes.Equal(buf, msg) {
+		t.Errorf("loaded %d bytes, want %d", len(buf), len(msg))
+	}
+}
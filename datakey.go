@@ -0,0 +1,181 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+var (
+	// DataKeyTimeout limits each request to the key management service.
+	DataKeyTimeout = 30 * time.Second
+
+	// DataKeyCacheTTL is how long unwrapped data keys are kept in memory by default.
+	DataKeyCacheTTL = 5 * time.Minute
+)
+
+// dataKeyProvider generates data keys and unwraps them using a key management
+// service. The object name is passed along so it can be bound to the wrapped key.
+type dataKeyProvider interface {
+	generateDataKey(ctx context.Context, name string) (plaintext, wrapped []byte, err error)
+	unwrapDataKey(ctx context.Context, name string, wrapped []byte) ([]byte, error)
+}
+
+// dataKeyIO implements envelope encryption with a key management service: every
+// object is encrypted with AES-256-GCM using its own data key, which is stored
+// in the object wrapped by the key management service. The payload following
+// the envelope is
+//
+//	wrapped key length (2 bytes) | wrapped key | nonce | ciphertext
+//
+// The envelope and the object name are authenticated as associated data.
+type dataKeyIO struct {
+	algorithm Algorithm
+	provider  dataKeyProvider
+	cacheTTL  time.Duration
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]cachedDataKey
+}
+
+type cachedDataKey struct {
+	key     []byte
+	expires time.Time
+}
+
+func newDataKeyIO(algorithm Algorithm, provider dataKeyProvider, cacheTTL time.Duration) dataKeyIO {
+	return dataKeyIO{
+		algorithm: algorithm,
+		provider:  provider,
+		cacheTTL:  cacheTTL,
+		cache:     make(map[[sha256.Size]byte]cachedDataKey),
+	}
+}
+
+func (d *dataKeyIO) WrapReader(name string, r io.Reader) io.Reader {
+	allData, err := io.ReadAll(r)
+	if err != nil {
+		return &Reader{nil, 0, err}
+	}
+
+	if len(allData) == 0 {
+		return bytes.NewReader(nil)
+	}
+
+	env, payload, ok := ParseEnvelope(allData)
+	if !ok || env.Algorithm != d.algorithm {
+		return &Reader{nil, 0, fmt.Errorf("decryption failed: object is not encrypted with %s", d.algorithm)}
+	}
+	header := allData[:len(allData)-len(payload)]
+
+	if len(payload) < 2 || len(payload) < 2+int(binary.BigEndian.Uint16(payload)) {
+		return &Reader{nil, 0, errors.New("insufficient data for decryption: missing data key")}
+	}
+	l := int(binary.BigEndian.Uint16(payload))
+	wrapped, payload := payload[2:2+l], payload[2+l:]
+
+	dataKey, err := d.unwrapKey(name, wrapped)
+	if err != nil {
+		return &Reader{nil, 0, fmt.Errorf("decryption failed: %w", err)}
+	}
+	aead, err := newGCM(dataKey)
+	clear(dataKey)
+	if err != nil {
+		return &Reader{nil, 0, err}
+	}
+
+	bout, err := aeadOpen(aead, name, header, payload)
+	if err != nil {
+		return &Reader{nil, 0, err}
+	}
+	return bytes.NewReader(bout)
+}
+
+func (d *dataKeyIO) ByteReader(name string, msg []byte) Reader {
+	ctx, cancel := context.WithTimeout(context.Background(), DataKeyTimeout)
+	defer cancel()
+
+	dataKey, wrapped, err := d.provider.generateDataKey(ctx, name)
+	if err != nil {
+		return Reader{nil, 0, fmt.Errorf("failed to generate data key: %w", err)}
+	}
+	if len(wrapped) > 0xffff {
+		return Reader{nil, 0, errors.New("wrapped data key is too large")}
+	}
+
+	aead, err := newGCM(dataKey)
+	clear(dataKey)
+	if err != nil {
+		return Reader{nil, 0, err}
+	}
+
+	headerLen := envelopeLen("")
+	out := AppendEnvelope(make([]byte, 0, headerLen+2+len(wrapped)+aead.NonceSize()+len(msg)+aead.Overhead()), d.algorithm, "")
+	out = binary.BigEndian.AppendUint16(out, uint16(len(wrapped)))
+	out = append(out, wrapped...)
+
+	nonce := out[len(out) : len(out)+aead.NonceSize()]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return Reader{nil, 0, err}
+	}
+	out = out[:len(out)+aead.NonceSize()]
+
+	out = aead.Seal(out, nonce, msg, associatedData(out[:headerLen], name))
+	return Reader{bytes.NewReader(out), int64(len(out)), nil}
+}
+
+// unwrapKey unwraps the data key with the provider, or returns it from the
+// cache. The caller owns the returned key and should wipe it after use.
+func (d *dataKeyIO) unwrapKey(name string, wrapped []byte) ([]byte, error) {
+	// the object name is part of the cache key, as providers may bind it to the wrapped key
+	id := sha256.Sum256(append([]byte(name+"\x00"), wrapped...))
+
+	d.mu.Lock()
+	cached, ok := d.cache[id]
+	if ok && time.Now().Before(cached.expires) {
+		key := bytes.Clone(cached.key)
+		d.mu.Unlock()
+		return key, nil
+	}
+	d.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), DataKeyTimeout)
+	defer cancel()
+
+	key, err := d.provider.unwrapDataKey(ctx, name, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.cacheTTL > 0 {
+		now := time.Now()
+		for cacheID, entry := range d.cache {
+			if now.After(entry.expires) {
+				clear(entry.key)
+				delete(d.cache, cacheID)
+			}
+		}
+		d.cache[id] = cachedDataKey{key: bytes.Clone(key), expires: now.Add(d.cacheTTL)}
+	}
+	return key, nil
+}
+
+// wipe drops all cached data keys and disables the cache.
+func (d *dataKeyIO) wipe() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for id, entry := range d.cache {
+		clear(entry.key)
+		delete(d.cache, id)
+	}
+	d.cacheTTL = 0
+}
@@ -0,0 +1,174 @@
+package s3
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// EnvelopeMagic starts every object written by an IO implementation.
+const EnvelopeMagic = "CMS3"
+
+const (
+	// EnvelopeVersion is the current envelope format version.
+	EnvelopeVersion = 2
+
+	// EnvelopeVersionStream has the same header as EnvelopeVersion, but the
+	// payload is split into separately authenticated chunks, see streamSealer.
+	EnvelopeVersionStream = 3
+
+	maxKeyIDLength = 255
+	maxEnvelopeLen = len(EnvelopeMagic) + 3 + maxKeyIDLength
+)
+
+// Algorithm identifies how the payload of an envelope is protected.
+type Algorithm byte
+
+const (
+	AlgorithmNone              Algorithm = 0
+	AlgorithmSecretBox         Algorithm = 1
+	AlgorithmXChaCha20Poly1305 Algorithm = 2
+	AlgorithmAES256GCM         Algorithm = 3
+	AlgorithmKMS               Algorithm = 4
+	AlgorithmVaultTransit      Algorithm = 5
+	AlgorithmAge               Algorithm = 6
+
+	// compression algorithms, used for the envelope nested inside the
+	// (encrypted) payload by CompressIO
+	AlgorithmGzip Algorithm = 7
+	AlgorithmZstd Algorithm = 8
+
+	// AlgorithmHMACSHA256 authenticates, but does not encrypt the payload.
+	AlgorithmHMACSHA256 Algorithm = 9
+)
+
+var algorithmNames = map[Algorithm]string{
+	AlgorithmNone:              "none",
+	AlgorithmSecretBox:         "secretbox",
+	AlgorithmXChaCha20Poly1305: "xchacha20-poly1305",
+	AlgorithmAES256GCM:         "aes-256-gcm",
+	AlgorithmKMS:               "aws-kms",
+	AlgorithmVaultTransit:      "vault-transit",
+	AlgorithmAge:               "age",
+	AlgorithmGzip:              "gzip",
+	AlgorithmZstd:              "zstd",
+	AlgorithmHMACSHA256:        "hmac-sha256",
+}
+
+func (a Algorithm) String() string {
+	if name, ok := algorithmNames[a]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", byte(a))
+}
+
+// encrypts reports whether a is an encryption algorithm.
+func (a Algorithm) encrypts() bool {
+	switch a {
+	case AlgorithmNone, AlgorithmGzip, AlgorithmZstd, AlgorithmHMACSHA256:
+		return false
+	}
+	return true
+}
+
+// ParseAlgorithm returns the encryption algorithm with the given name.
+func ParseAlgorithm(name string) (Algorithm, error) {
+	for a, n := range algorithmNames {
+		if n == name && a.encrypts() {
+			return a, nil
+		}
+	}
+	return AlgorithmNone, fmt.Errorf("unknown encryption algorithm: %s", name)
+}
+
+// newKeyedCipher returns the IO implementation of algorithm using key.
+func newKeyedCipher(algorithm Algorithm, key [32]byte) (keyedCipher, error) {
+	switch algorithm {
+	case AlgorithmSecretBox:
+		return NewSecretBoxIO(key), nil
+	case AlgorithmXChaCha20Poly1305:
+		return NewXChaChaIO(key)
+	case AlgorithmAES256GCM:
+		return NewAESGCMIO(key)
+	}
+	return nil, fmt.Errorf("%s does not support encryption keys", algorithm)
+}
+
+// Envelope is the self-describing header in front of every stored object:
+//
+//	magic (4 bytes) | version (1 byte) | algorithm (1 byte) | key ID length (1 byte) | key ID
+//
+// Objects written before the envelope was introduced have no header at all.
+type Envelope struct {
+	Version   byte
+	Algorithm Algorithm
+	KeyID     string
+}
+
+// AppendEnvelope appends the header for algorithm and keyID to buf.
+func AppendEnvelope(buf []byte, algorithm Algorithm, keyID string) []byte {
+	return appendEnvelopeVersion(buf, EnvelopeVersion, algorithm, keyID)
+}
+
+func appendEnvelopeVersion(buf []byte, version byte, algorithm Algorithm, keyID string) []byte {
+	buf = append(buf, EnvelopeMagic...)
+	buf = append(buf, version, byte(algorithm), byte(len(keyID)))
+	return append(buf, keyID...)
+}
+
+// envelopeLen returns the length of the header for keyID.
+func envelopeLen(keyID string) int {
+	return len(EnvelopeMagic) + 3 + len(keyID)
+}
+
+// ParseEnvelope parses the header at the start of buf and returns it together
+// with the payload following it. ok is false for data without a header.
+func ParseEnvelope(buf []byte) (env Envelope, payload []byte, ok bool) {
+	if !bytes.HasPrefix(buf, []byte(EnvelopeMagic)) {
+		return env, nil, false
+	}
+	buf = buf[len(EnvelopeMagic):]
+
+	if len(buf) < 1 {
+		return env, nil, false
+	}
+	env.Version = buf[0]
+	buf = buf[1:]
+
+	switch env.Version {
+	case EnvelopeVersion, EnvelopeVersionStream:
+		if len(buf) < 1 {
+			return env, nil, false
+		}
+		env.Algorithm = Algorithm(buf[0])
+		buf = buf[1:]
+	default:
+		return env, nil, false
+	}
+
+	if len(buf) < 1 || len(buf) < 1+int(buf[0]) {
+		return env, nil, false
+	}
+	l := int(buf[0])
+	env.KeyID = string(buf[1 : 1+l])
+	return env, buf[1+l:], true
+}
+
+// peekEnvelope parses the header at the start of r without consuming it, so
+// that implementations can decide how to read the rest of the object. The
+// returned reader yields all data of r, including the header.
+func peekEnvelope(r io.Reader) (env Envelope, header []byte, br *bufio.Reader, ok bool, err error) {
+	br = bufio.NewReaderSize(r, maxEnvelopeLen)
+	buf, err := br.Peek(maxEnvelopeLen)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return env, nil, nil, false, err
+	}
+
+	env, payload, ok := ParseEnvelope(buf)
+	if ok {
+		header = bytes.Clone(buf[:len(buf)-len(payload)])
+	}
+	return env, header, br, ok, nil
+}
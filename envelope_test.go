@@ -0,0 +1,90 @@
+package s3
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestParseEnvelope(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		ok      bool
+		want    Envelope
+		payload []byte
+	}{
+		{
+			name:    "current version",
+			data:    append(AppendEnvelope(nil, AlgorithmSecretBox, "2025"), "payload"...),
+			ok:      true,
+			want:    Envelope{Version: EnvelopeVersion, Algorithm: AlgorithmSecretBox, KeyID: "2025"},
+			payload: []byte("payload"),
+		},
+		{
+			name:    "without key ID",
+			data:    append(AppendEnvelope(nil, AlgorithmNone, ""), "payload"...),
+			ok:      true,
+			want:    Envelope{Version: EnvelopeVersion, Algorithm: AlgorithmNone},
+			payload: []byte("payload"),
+		},
+		{
+			name:    "streamed payload",
+			data:    append(appendEnvelopeVersion(nil, EnvelopeVersionStream, AlgorithmAES256GCM, "k"), "payload"...),
+			ok:      true,
+			want:    Envelope{Version: EnvelopeVersionStream, Algorithm: AlgorithmAES256GCM, KeyID: "k"},
+			payload: []byte("payload"),
+		},
+		{
+			name: "no envelope",
+			data: []byte("-----BEGIN CERTIFICATE-----"),
+		},
+		{
+			name: "unknown version",
+			data: []byte("CMS3\x09\x01\x00payload"),
+		},
+		{
+			name: "truncated key ID",
+			data: []byte("CMS3\x02\x01\x10short"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, payload, ok := ParseEnvelope(tt.data)
+			if ok != tt.ok {
+				t.Fatalf("ParseEnvelope() ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if env != tt.want {
+				t.Errorf("ParseEnvelope() = %+v, want %+v", env, tt.want)
+			}
+			if !bytes.Equal(payload, tt.payload) {
+				t.Errorf("ParseEnvelope() payload = %q, want %q", payload, tt.payload)
+			}
+		})
+	}
+}
+
+func TestPeekEnvelope(t *testing.T) {
+	for _, data := range [][]byte{
+		append(AppendEnvelope(nil, AlgorithmNone, "key"), "payload"...),
+		[]byte("CMS"),
+		nil,
+	} {
+		env, header, br, ok, err := peekEnvelope(bytes.NewReader(data))
+		assertNoError(t, err, "peekEnvelope()")
+		if wantEnv, payload, wantOK := ParseEnvelope(data); ok != wantOK || env != wantEnv || (ok && len(header) != len(data)-len(payload)) {
+			t.Errorf("peekEnvelope(%q) = %+v, %q, %v", data, env, header, ok)
+		}
+
+		// the header is not consumed
+		buf, err := io.ReadAll(br)
+		assertNoError(t, err, "reading")
+		if !bytes.Equal(buf, data) {
+			t.Errorf("read %q after peekEnvelope(), want %q", buf, data)
+		}
+	}
+}
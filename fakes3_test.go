@@ -0,0 +1,293 @@
+package s3
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	s3sdk "github.com/aws/aws-sdk-go-v2/service/s3"
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+)
+
+const testBucket = "test-bucket"
+
+type fakeObject struct {
+	data     []byte
+	etag     string
+	modified time.Time
+	header   http.Header
+}
+
+// fakeS3 is a minimal in-memory implementation of the S3 API operations used by
+// this module, served with path-style addressing.
+type fakeS3 struct {
+	mu       sync.Mutex
+	objects  map[string]*fakeObject
+	requests map[string]int
+	failing  bool
+	gate     chan struct{}
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *httptest.Server) {
+	f := &fakeS3{
+		objects:  make(map[string]*fakeObject),
+		requests: make(map[string]int),
+	}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+// newTestS3 returns a provisioned cleartext S3 storage backed by a fakeS3.
+func newTestS3(t *testing.T) (*S3, *fakeS3) {
+	f, srv := newFakeS3(t)
+	return &S3{
+		Logger: zap.NewNop(),
+		Client: newFakeS3Client(srv.URL),
+		Bucket: testBucket,
+		Prefix: "acme",
+		iowrap: &CleartextIO{},
+		flight: new(singleflight.Group),
+	}, f
+}
+
+func newFakeS3Client(endpoint string) *s3sdk.Client {
+	return s3sdk.New(s3sdk.Options{
+		Region:                     "us-east-1",
+		BaseEndpoint:               aws.String(endpoint),
+		UsePathStyle:               true,
+		Credentials:                credentials.NewStaticCredentialsProvider("AKID", "SECRET", ""),
+		RequestChecksumCalculation: aws.RequestChecksumCalculationWhenRequired,
+		ResponseChecksumValidation: aws.ResponseChecksumValidationWhenRequired,
+		RetryMaxAttempts:           1,
+	})
+}
+
+func (f *fakeS3) put(key string, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[key] = newFakeObject(data, nil)
+}
+
+func (f *fakeS3) get(key string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	obj, ok := f.objects[key]
+	if !ok {
+		return nil, false
+	}
+	return obj.data, true
+}
+
+func (f *fakeS3) header(key string) http.Header {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if obj, ok := f.objects[key]; ok {
+		return obj.header
+	}
+	return nil
+}
+
+func (f *fakeS3) count(method string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests[method]
+}
+
+func (f *fakeS3) setFailing(failing bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failing = failing
+}
+
+func newFakeObject(data []byte, header http.Header) *fakeObject {
+	sum := md5.Sum(data) // #nosec G401
+	return &fakeObject{
+		data:     data,
+		etag:     `"` + hex.EncodeToString(sum[:]) + `"`,
+		modified: time.Now().UTC().Truncate(time.Second),
+		header:   header,
+	}
+}
+
+type fakeListResult struct {
+	XMLName     xml.Name `xml:"ListBucketResult"`
+	Name        string   `xml:"Name"`
+	Prefix      string   `xml:"Prefix"`
+	KeyCount    int      `xml:"KeyCount"`
+	IsTruncated bool     `xml:"IsTruncated"`
+	Contents    []struct {
+		Key          string `xml:"Key"`
+		Size         int    `xml:"Size"`
+		ETag         string `xml:"ETag"`
+		LastModified string `xml:"LastModified"`
+	} `xml:"Contents"`
+}
+
+// block makes requests wait until release is called. They are counted before.
+func (f *fakeS3) block() (release func()) {
+	gate := make(chan struct{})
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.gate = gate
+
+	return func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.gate = nil
+		close(gate)
+	}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.requests[r.Method]++
+	gate := f.gate
+	f.mu.Unlock()
+	if gate != nil {
+		<-gate
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.failing {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	bucket, key, _ := strings.Cut(path, "/")
+	if bucket != testBucket {
+		writeFakeError(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	if key == "" && r.Method == http.MethodGet {
+		f.list(w, r.URL.Query().Get("prefix"), r.URL.Query().Get("start-after"))
+		return
+	}
+
+	obj, exists := f.objects[key]
+	switch r.Method {
+	case http.MethodPut:
+		if r.Header.Get("If-None-Match") == "*" && exists {
+			writeFakeError(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && (!exists || ifMatch != obj.etag) {
+			writeFakeError(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		obj = newFakeObject(data, r.Header.Clone())
+		f.objects[key] = obj
+		w.Header().Set("ETag", obj.etag)
+	case http.MethodGet, http.MethodHead:
+		if !exists {
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			writeFakeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		// like S3, objects encrypted with SSE-C can only be read with the same key
+		sseKeyMD5 := "X-Amz-Server-Side-Encryption-Customer-Key-Md5"
+		if obj.header.Get(sseKeyMD5) != r.Header.Get(sseKeyMD5) {
+			writeFakeError(w, http.StatusBadRequest, "InvalidRequest")
+			return
+		}
+		w.Header().Set("ETag", obj.etag)
+		w.Header().Set("Last-Modified", obj.modified.Format(http.TimeFormat))
+		for name, values := range obj.header {
+			if strings.HasPrefix(strings.ToLower(name), "x-amz-meta-") {
+				w.Header()[name] = values
+			}
+		}
+		if r.Header.Get("If-None-Match") == obj.etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(obj.data)))
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(obj.data)
+		}
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, prefix, startAfter string) {
+	result := fakeListResult{Name: testBucket, Prefix: prefix}
+	keys := make([]string, 0, len(f.objects))
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) && key > startAfter {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		obj := f.objects[key]
+		result.Contents = append(result.Contents, struct {
+			Key          string `xml:"Key"`
+			Size         int    `xml:"Size"`
+			ETag         string `xml:"ETag"`
+			LastModified string `xml:"LastModified"`
+		}{key, len(obj.data), obj.etag, obj.modified.Format(time.RFC3339)})
+	}
+	result.KeyCount = len(result.Contents)
+
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(result)
+}
+
+func writeFakeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_, _ = io.WriteString(w, "<Error><Code>"+code+"</Code><Message>"+code+"</Message></Error>")
+}
+
+func TestFakeS3(t *testing.T) {
+	s3, _ := newTestS3(t)
+	ctx := context.Background()
+
+	assertNoError(t, s3.Store(ctx, "a/b.crt", []byte("certificate")), "Store()")
+	buf, err := s3.Load(ctx, "a/b.crt")
+	assertNoError(t, err, "Load()")
+	if string(buf) != "certificate" {
+		t.Errorf("Load() = %q, want %q", buf, "certificate")
+	}
+	if !s3.Exists(ctx, "a/b.crt") {
+		t.Error("Exists() = false, want true")
+	}
+	keys, err := s3.List(ctx, "", true)
+	assertNoError(t, err, "List()")
+	if len(keys) != 1 {
+		t.Errorf("List() = %v, want one key", keys)
+	}
+	assertNoError(t, s3.Delete(ctx, "a/b.crt"), "Delete()")
+	if s3.Exists(ctx, "a/b.crt") {
+		t.Error("Exists() after Delete() = true, want false")
+	}
+}
@@ -0,0 +1,175 @@
+package s3
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/smithy-go"
+	"go.uber.org/zap"
+)
+
+// FallbackMaxAge is how long values in the fallback directory are served
+// during S3 outages by default.
+var FallbackMaxAge = 7 * 24 * time.Hour
+
+// diskCache keeps a copy of stored and loaded values in a local directory,
+// wrapped by the same IO as the objects in S3, to load them while S3 is
+// unavailable. Files are named by the SHA-256 of the object name, and their
+// modification time is the last time they were known to match S3.
+type diskCache struct {
+	dir    string
+	maxAge time.Duration
+	iowrap IO
+}
+
+func (s3 *S3) setupFallback() error {
+	if s3.FallbackDir == "" {
+		if s3.FallbackMaxAge != 0 {
+			return errors.New("'fallback_max_age' requires 'fallback_dir'")
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(s3.FallbackDir, 0o700); err != nil {
+		return fmt.Errorf("failed to create fallback directory: %w", err)
+	}
+	maxAge := time.Duration(s3.FallbackMaxAge)
+	if maxAge == 0 {
+		maxAge = FallbackMaxAge
+	}
+	s3.fallback = &diskCache{dir: s3.FallbackDir, maxAge: maxAge, iowrap: s3.iowrap}
+	return nil
+}
+
+// loadFallback loads key from the fallback directory after S3 failed with
+// loadErr. loadErr is returned if the value is missing or too old.
+func (s3 *S3) loadFallback(key string, loadErr error) ([]byte, error) {
+	objName := s3.objName(key)
+	data, age, err := s3.fallback.load(objName)
+	if err != nil {
+		s3.Logger.Error("S3 is unavailable and the fallback directory cannot be used",
+			zap.String("key", objName),
+			zap.NamedError("s3_error", loadErr),
+			zap.Error(err),
+		)
+		return nil, loadErr
+	}
+
+	s3.Logger.Warn("S3 is unavailable, loaded object from fallback directory",
+		zap.String("key", objName),
+		zap.Duration("age", age),
+		zap.NamedError("s3_error", loadErr),
+	)
+	return data, nil
+}
+
+// storeFallback writes the object read from r, as stored in S3, to the
+// fallback directory. Failures are logged, as S3 remains the source of truth.
+func (s3 *S3) storeFallback(objName string, r io.Reader) {
+	if err := s3.fallback.store(objName, r); err != nil {
+		s3.Logger.Warn("failed to write object to fallback directory",
+			zap.String("key", objName),
+			zap.Error(err),
+		)
+	}
+}
+
+// removeFallback removes objName from the fallback directory.
+func (s3 *S3) removeFallback(objName string) {
+	if err := s3.fallback.remove(objName); err != nil {
+		s3.Logger.Warn("failed to remove object from fallback directory",
+			zap.String("key", objName),
+			zap.Error(err),
+		)
+	}
+}
+
+// isUnavailable reports whether err means that S3 could not be reached or
+// failed to handle the request, rather than rejecting it.
+func isUnavailable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var oe *smithy.OperationError
+	if !errors.As(err, &oe) {
+		// e.g. objects that failed to decrypt
+		return false
+	}
+	var re *awshttp.ResponseError
+	if errors.As(err, &re) {
+		return re.HTTPStatusCode() >= http.StatusInternalServerError || re.HTTPStatusCode() == http.StatusTooManyRequests
+	}
+	return true
+}
+
+func (c *diskCache) path(objName string) string {
+	sum := sha256.Sum256([]byte(objName))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
+
+// store atomically replaces the file of objName with the object read from r,
+// which is already wrapped by iowrap.
+func (c *diskCache) store(objName string, r io.Reader) error {
+	f, err := os.CreateTemp(c.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+
+	if _, err := io.Copy(f, r); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), c.path(objName))
+}
+
+// load returns the value of objName and how long ago it was known to match S3.
+func (c *diskCache) load(objName string) ([]byte, time.Duration, error) {
+	f, err := os.Open(c.path(objName))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() { _ = f.Close() }()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	age := time.Since(info.ModTime())
+	if c.maxAge > 0 && age > c.maxAge {
+		return nil, age, fmt.Errorf("fallback copy is %s old, older than %s", age.Round(time.Second), c.maxAge)
+	}
+
+	data, err := io.ReadAll(c.iowrap.WrapReader(objName, f))
+	if err != nil {
+		return nil, age, fmt.Errorf("failed to read/decrypt fallback copy: %w", err)
+	}
+	return data, age, nil
+}
+
+// touch records that the file of objName still matches S3.
+func (c *diskCache) touch(objName string) {
+	now := time.Now()
+	_ = os.Chtimes(c.path(objName), now, now)
+}
+
+func (c *diskCache) remove(objName string) error {
+	err := os.Remove(c.path(objName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,136 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestS3_Fallback(t *testing.T) {
+	ctx := context.Background()
+	cert := []byte("-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n")
+
+	newStorage := func(t *testing.T, maxAge time.Duration) (*S3, *fakeS3) {
+		s3, fake := newTestS3(t)
+		s3.iowrap = NewSecretBoxIO(testKey32)
+		s3.fallback = &diskCache{dir: t.TempDir(), maxAge: maxAge, iowrap: s3.iowrap}
+		assertNoError(t, s3.Store(ctx, "site.crt", cert), "Store()")
+		return s3, fake
+	}
+
+	t.Run("unavailable", func(t *testing.T) {
+		s3, fake := newStorage(t, time.Hour)
+		fake.setFailing(true)
+		buf, err := s3.Load(ctx, "site.crt")
+		assertNoError(t, err, "Load()")
+		if !bytes.Equal(buf, cert) {
+			t.Errorf("Load() = %q, want %q", buf, cert)
+		}
+
+		_, err = s3.Load(ctx, "missing.crt")
+		assertError(t, err, "failed to load key", "Load(missing.crt)")
+	})
+
+	t.Run("encrypted", func(t *testing.T) {
+		s3, _ := newStorage(t, time.Hour)
+		raw, err := os.ReadFile(s3.fallback.path(s3.objName("site.crt")))
+		assertNoError(t, err, "ReadFile()")
+		if bytes.Contains(raw, cert) {
+			t.Error("fallback copy is not encrypted")
+		}
+	})
+
+	t.Run("stale", func(t *testing.T) {
+		s3, fake := newStorage(t, time.Hour)
+		old := time.Now().Add(-2 * time.Hour)
+		assertNoError(t, os.Chtimes(s3.fallback.path(s3.objName("site.crt")), old, old), "Chtimes()")
+
+		fake.setFailing(true)
+		_, err := s3.Load(ctx, "site.crt")
+		assertError(t, err, "failed to load key", "Load()")
+
+		// loading from S3 refreshes the copy
+		fake.setFailing(false)
+		_, err = s3.Load(ctx, "site.crt")
+		assertNoError(t, err, "Load()")
+		fake.setFailing(true)
+		_, err = s3.Load(ctx, "site.crt")
+		assertNoError(t, err, "Load()")
+	})
+
+	t.Run("loaded", func(t *testing.T) {
+		s3, fake := newTestS3(t)
+		fake.put(s3.objName("other.crt"), cert)
+		s3.fallback = &diskCache{dir: t.TempDir(), maxAge: time.Hour, iowrap: s3.iowrap}
+		_, err := s3.Load(ctx, "other.crt")
+		assertNoError(t, err, "Load()")
+
+		fake.setFailing(true)
+		buf, err := s3.Load(ctx, "other.crt")
+		assertNoError(t, err, "Load()")
+		if !bytes.Equal(buf, cert) {
+			t.Errorf("Load() = %q, want %q", buf, cert)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		s3, _ := newStorage(t, time.Hour)
+		assertNoError(t, s3.Delete(ctx, "site.crt"), "Delete()")
+		if _, err := s3.Load(ctx, "site.crt"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Load() after Delete() error = %v, want fs.ErrNotExist", err)
+		}
+		if _, err := os.Stat(s3.fallback.path(s3.objName("site.crt"))); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("fallback copy was not removed: %v", err)
+		}
+	})
+
+	t.Run("data keys", func(t *testing.T) {
+		s3, fake := newTestS3(t)
+		k, kms := newTestKMSIO(t, 0)
+		s3.iowrap = k
+		s3.fallback = &diskCache{dir: t.TempDir(), maxAge: time.Hour, iowrap: s3.iowrap}
+		assertNoError(t, s3.Store(ctx, "site.crt", cert), "Store()")
+		for i := 0; i < 3; i++ {
+			_, err := s3.Load(ctx, "site.crt")
+			assertNoError(t, err, "Load()")
+		}
+		// the copy is written as read from S3, without wrapping it again
+		if got := kms.generateCount(); got != 1 {
+			t.Errorf("generated %d data keys, want 1", got)
+		}
+
+		fake.setFailing(true)
+		buf, err := s3.Load(ctx, "site.crt")
+		assertNoError(t, err, "Load()")
+		if !bytes.Equal(buf, cert) {
+			t.Errorf("Load() = %q, want %q", buf, cert)
+		}
+	})
+
+	t.Run("decryption errors", func(t *testing.T) {
+		s3, fake := newStorage(t, time.Hour)
+		fake.put(s3.objName("site.crt"), []byte("tampered"))
+		_, err := s3.Load(ctx, "site.crt")
+		assertError(t, err, "failed to read/decrypt", "Load()")
+	})
+}
+
+func TestS3_setupFallback(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "fallback")
+	s3 := &S3{FallbackDir: dir, iowrap: &CleartextIO{}}
+	assertNoError(t, s3.setupFallback(), "setupFallback()")
+	if s3.fallback.maxAge != FallbackMaxAge {
+		t.Errorf("maxAge = %v, want %v", s3.fallback.maxAge, FallbackMaxAge)
+	}
+	if info, err := os.Stat(dir); err != nil || info.Mode().Perm() != 0o700 {
+		t.Errorf("fallback directory not created with mode 0700: %v", err)
+	}
+
+	err := (&S3{FallbackMaxAge: 1}).setupFallback()
+	assertError(t, err, "requires 'fallback_dir'", "setupFallback()")
+}
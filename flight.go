@@ -0,0 +1,68 @@
+package s3
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	s3sdk "github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Prefixes of the keys of s3.flight.
+const (
+	flightLoad = "load:"
+	flightHead = "head:"
+)
+
+// coalesce calls fn once for all concurrent callers with the same key. fn is
+// not canceled when the caller that started it is, so that the others still
+// get its result, but each caller stops waiting when its own ctx is done.
+func (s3 *S3) coalesce(ctx context.Context, key string, fn func(ctx context.Context) (any, error)) (any, error) {
+	if s3.flight == nil {
+		// not provisioned
+		return fn(ctx)
+	}
+
+	ch := s3.flight.DoChan(key, func() (any, error) {
+		shared := context.WithoutCancel(ctx)
+		if deadline, ok := ctx.Deadline(); ok {
+			var cancel context.CancelFunc
+			shared, cancel = context.WithDeadline(shared, deadline)
+			defer cancel()
+		}
+		return fn(shared)
+	})
+
+	select {
+	case res := <-ch:
+		return res.Val, res.Err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// forget makes calls for objName that start after a Store or Delete not
+// share the results of calls that started before.
+func (s3 *S3) forget(objName string) {
+	if s3.flight == nil {
+		return
+	}
+	s3.flight.Forget(flightLoad + objName)
+	s3.flight.Forget(flightHead + objName)
+}
+
+// headObject returns the metadata of objName, sharing the request between
+// concurrent Stat and Exists calls.
+func (s3 *S3) headObject(ctx context.Context, objName string) (*s3sdk.HeadObjectOutput, error) {
+	res, err := s3.coalesce(ctx, flightHead+objName, func(ctx context.Context) (any, error) {
+		input := &s3sdk.HeadObjectInput{
+			Bucket: aws.String(s3.Bucket),
+			Key:    aws.String(objName),
+		}
+		s3.applySSEHead(input)
+		return s3.Client.HeadObject(ctx, input)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.(*s3sdk.HeadObjectOutput), nil
+}
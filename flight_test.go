@@ -0,0 +1,154 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitForRequests waits until fake received n requests with method.
+func waitForRequests(t *testing.T, fake *fakeS3, method string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for fake.count(method) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("received %d %s requests, want %d", fake.count(method), method, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestS3_coalesce(t *testing.T) {
+	ctx := context.Background()
+	cert := []byte("-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n")
+	const callers = 10
+
+	t.Run("Load", func(t *testing.T) {
+		s3, fake := newTestS3(t)
+		assertNoError(t, s3.Store(ctx, "site.crt", cert), "Store()")
+
+		release := fake.block()
+		var wg sync.WaitGroup
+		results := make([][]byte, callers)
+		for i := range callers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				buf, err := s3.Load(ctx, "site.crt")
+				assertNoError(t, err, "Load()")
+				results[i] = buf
+			}()
+		}
+		waitForRequests(t, fake, http.MethodGet, 1)
+		// give the other callers time to join the request
+		time.Sleep(50 * time.Millisecond)
+		release()
+		wg.Wait()
+
+		if n := fake.count(http.MethodGet); n != 1 {
+			t.Errorf("sent %d GET requests, want 1", n)
+		}
+		for _, buf := range results {
+			if !bytes.Equal(buf, cert) {
+				t.Fatalf("Load() = %q, want %q", buf, cert)
+			}
+		}
+		// every caller gets its own copy
+		results[0][0] = 'x'
+		if results[1][0] == 'x' {
+			t.Error("Load() results share memory")
+		}
+	})
+
+	t.Run("Stat and Exists", func(t *testing.T) {
+		s3, fake := newTestS3(t)
+		assertNoError(t, s3.Store(ctx, "site.crt", cert), "Store()")
+
+		release := fake.block()
+		var wg sync.WaitGroup
+		for range callers {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				_, err := s3.Stat(ctx, "site.crt")
+				assertNoError(t, err, "Stat()")
+			}()
+			go func() {
+				defer wg.Done()
+				if !s3.Exists(ctx, "site.crt") {
+					t.Error("Exists() = false")
+				}
+			}()
+		}
+		waitForRequests(t, fake, http.MethodHead, 1)
+		time.Sleep(50 * time.Millisecond)
+		release()
+		wg.Wait()
+
+		if n := fake.count(http.MethodHead); n != 1 {
+			t.Errorf("sent %d HEAD requests, want 1", n)
+		}
+	})
+
+	t.Run("Store", func(t *testing.T) {
+		s3, fake := newTestS3(t)
+		assertNoError(t, s3.Store(ctx, "site.crt", cert), "Store()")
+
+		release := fake.block()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_, _ = s3.Load(ctx, "site.crt")
+		}()
+		waitForRequests(t, fake, http.MethodGet, 1)
+
+		// loads after a Store do not share the result of loads before it
+		renewed := []byte("renewed")
+		stored := make(chan error)
+		go func() { stored <- s3.Store(ctx, "site.crt", renewed) }()
+		waitForRequests(t, fake, http.MethodPut, 2)
+		release()
+		assertNoError(t, <-stored, "Store()")
+		<-done
+
+		buf, err := s3.Load(ctx, "site.crt")
+		assertNoError(t, err, "Load()")
+		if !bytes.Equal(buf, renewed) {
+			t.Errorf("Load() = %q, want %q", buf, renewed)
+		}
+	})
+
+	t.Run("canceled caller", func(t *testing.T) {
+		s3, fake := newTestS3(t)
+		assertNoError(t, s3.Store(ctx, "site.crt", cert), "Store()")
+
+		release := fake.block()
+		canceled, cancel := context.WithCancel(ctx)
+		first := make(chan error)
+		go func() {
+			_, err := s3.Load(canceled, "site.crt")
+			first <- err
+		}()
+		waitForRequests(t, fake, http.MethodGet, 1)
+
+		second := make(chan error)
+		go func() {
+			buf, err := s3.Load(ctx, "site.crt")
+			if err == nil && !bytes.Equal(buf, cert) {
+				err = errors.New("unexpected value")
+			}
+			second <- err
+		}()
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+		if err := <-first; !errors.Is(err, context.Canceled) {
+			t.Errorf("Load() error = %v, want context.Canceled", err)
+		}
+		release()
+		assertNoError(t, <-second, "Load() of the other caller")
+	})
+}
@@ -0,0 +1,90 @@
+package s3
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestHMACIO(t *testing.T) {
+	h := NewHMACIO(testKey32)
+	msg := []byte("-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n")
+
+	r := h.ByteReader(testObjName, msg)
+	stored, err := io.ReadAll(&r)
+	assertNoError(t, err, "storing")
+	if int64(len(stored)) != r.Len() {
+		t.Errorf("Len() = %d, but read %d bytes", r.Len(), len(stored))
+	}
+	if !bytes.HasSuffix(stored, append([]byte("\n"), msg...)) {
+		t.Errorf("stored %q, want readable data after the tag", stored)
+	}
+
+	t.Run("roundtrip", func(t *testing.T) {
+		buf, err := io.ReadAll(h.WrapReader(testObjName, bytes.NewReader(stored)))
+		assertNoError(t, err, "loading")
+		if !bytes.Equal(buf, msg) {
+			t.Errorf("loaded %q, want %q", buf, msg)
+		}
+	})
+
+	r = NewHMACIO([32]byte{1}).ByteReader(testObjName, msg)
+	otherKey, err := io.ReadAll(&r)
+	assertNoError(t, err, "storing with other key")
+
+	tests := []struct {
+		name    string
+		objName string
+		data    []byte
+		wantErr string
+	}{
+		{
+			name:    "modified data",
+			objName: testObjName,
+			data:    bytes.Replace(stored, []byte("MIIB"), []byte("MIIC"), 1),
+			wantErr: "object was modified",
+		},
+		{
+			name:    "truncated data",
+			objName: testObjName,
+			data:    stored[:len(stored)-1],
+			wantErr: "object was modified",
+		},
+		{
+			name:    "moved",
+			objName: "acme/certificates/other.org/other.org.crt",
+			data:    stored,
+			wantErr: "object was modified or moved",
+		},
+		{
+			name:    "other key",
+			objName: testObjName,
+			data:    otherKey,
+			wantErr: "object was modified",
+		},
+		{
+			name:    "untagged object",
+			objName: testObjName,
+			data:    msg,
+			wantErr: "object has no HMAC tag",
+		},
+		{
+			name:    "cleartext envelope",
+			objName: testObjName,
+			data:    append(AppendEnvelope(nil, AlgorithmNone, ""), msg...),
+			wantErr: "object uses none instead of hmac-sha256",
+		},
+		{
+			name:    "invalid tag",
+			objName: testObjName,
+			data:    append(AppendEnvelope(nil, AlgorithmHMACSHA256, ""), "not a tag\n"...),
+			wantErr: "HMAC tag",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := io.ReadAll(h.WrapReader(tt.objName, bytes.NewReader(tt.data)))
+			assertError(t, err, tt.wantErr, "loading")
+		})
+	}
+}
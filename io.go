@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto/rand"
 	"errors"
+	"fmt"
 	"io"
 
 	"golang.org/x/crypto/nacl/secretbox"
@@ -13,11 +14,48 @@ const (
 	NonceSize = 24
 )
 
+// IO wraps the data of stored objects. Both methods receive the name of the
+// object (see S3.objName), which implementations may authenticate to bind the
+// data to its location in the bucket.
+//
+// Implementations should not hold objects in memory twice: the reader returned
+// by WrapReader should unwrap the data while it is read from r, reporting errors
+// found later on from Read, and the Reader returned by ByteReader should produce
+// its output while it is read. Implementations based on constructions that need
+// the whole object at once, like SecretBoxIO, buffer instead.
 type IO interface {
-	WrapReader(io.Reader) io.Reader
-	ByteReader([]byte) Reader
+	WrapReader(name string, r io.Reader) io.Reader
+	ByteReader(name string, buf []byte) Reader
 }
 
+// keyedCipher is implemented by the encrypting IO implementations, so that
+// KeyringIO can use them with any of its keys.
+type keyedCipher interface {
+	IO
+
+	// seal encrypts msg into an envelope carrying keyID.
+	seal(name string, msg []byte, keyID string) Reader
+	// open decrypts the payload following header, which is empty for legacy objects.
+	open(name string, header, payload []byte) ([]byte, error)
+}
+
+// streamCipher is implemented by keyed ciphers writing EnvelopeVersionStream
+// objects, which are decrypted while they are read.
+type streamCipher interface {
+	keyedCipher
+
+	// openStream decrypts the payload following header from r.
+	openStream(name string, header []byte, r io.Reader) io.Reader
+}
+
+// wiper is implemented by IO implementations holding key material, which is
+// overwritten by wipe when the module is cleaned up. Afterwards, they fail.
+type wiper interface {
+	wipe()
+}
+
+var errKeyWiped = errors.New("encryption key was wiped")
+
 type Reader struct {
 	r   io.ReadSeeker
 	l   int64
@@ -44,14 +82,55 @@ func (r *Reader) Seek(offset int64, whence int) (int64, error) {
 	return r.r.Seek(offset, whence)
 }
 
+// CleartextIO stores data unencrypted behind an envelope header. Objects without
+// a header are returned as they are.
 type CleartextIO struct{}
 
-func (ci *CleartextIO) WrapReader(r io.Reader) io.Reader {
-	return r
+func (ci *CleartextIO) WrapReader(_ string, r io.Reader) io.Reader {
+	env, header, br, ok, err := peekEnvelope(r)
+	if err != nil {
+		return &Reader{nil, 0, err}
+	}
+
+	if !ok {
+		return br
+	}
+	if env.Algorithm != AlgorithmNone {
+		return &Reader{nil, 0, fmt.Errorf("object is encrypted with %s, but no encryption is configured", env.Algorithm)}
+	}
+	_, _ = br.Discard(len(header))
+	return br
 }
 
-func (ci *CleartextIO) ByteReader(buf []byte) Reader {
-	return Reader{bytes.NewReader(buf), int64(len(buf)), nil}
+func (ci *CleartextIO) ByteReader(_ string, buf []byte) Reader {
+	return newPrefixedReader(AppendEnvelope(nil, AlgorithmNone, ""), buf)
+}
+
+// newPrefixedReader returns a Reader for prefix followed by data, without copying data.
+func newPrefixedReader(prefix, data []byte) Reader {
+	size := int64(len(prefix) + len(data))
+	return Reader{io.NewSectionReader(prefixedReaderAt{prefix, data}, 0, size), size, nil}
+}
+
+type prefixedReaderAt struct {
+	prefix, data []byte
+}
+
+func (p prefixedReaderAt) ReadAt(buf []byte, off int64) (int, error) {
+	var n int
+	if off < int64(len(p.prefix)) {
+		n = copy(buf, p.prefix[off:])
+	}
+	if n < len(buf) {
+		dataOff := max(off-int64(len(p.prefix)), 0)
+		if dataOff < int64(len(p.data)) {
+			n += copy(buf[n:], p.data[dataOff:])
+		}
+	}
+	if n < len(buf) {
+		return n, io.EOF
+	}
+	return n, nil
 }
 
 type SecretBoxIO struct {
@@ -67,13 +146,17 @@ func (sb *SecretBoxIO) IsValid() bool {
 	return sb.SecretKey != zero
 }
 
+func (sb *SecretBoxIO) wipe() {
+	clear(sb.SecretKey[:])
+}
+
 func (sb *SecretBoxIO) makeNonce() ([24]byte, error) {
 	var nonce [24]byte
 	_, err := io.ReadFull(rand.Reader, nonce[:])
 	return nonce, err
 }
 
-func (sb *SecretBoxIO) WrapReader(r io.Reader) io.Reader {
+func (sb *SecretBoxIO) WrapReader(name string, r io.Reader) io.Reader {
 	if !sb.IsValid() {
 		return &Reader{nil, 0, errors.New("SecretBoxIO not properly initialized")}
 	}
@@ -87,22 +170,58 @@ func (sb *SecretBoxIO) WrapReader(r io.Reader) io.Reader {
 		return bytes.NewReader(nil)
 	}
 
-	if len(allData) < NonceSize {
-		return &Reader{nil, 0, errors.New("insufficient data for decryption: missing nonce")}
+	env, payload, ok := ParseEnvelope(allData)
+	if ok && env.Algorithm != AlgorithmSecretBox {
+		return &Reader{nil, 0, fmt.Errorf("decryption failed: object uses %s instead of secretbox", env.Algorithm)}
+	}
+	return openReader(sb, name, allData, ok, payload)
+}
+
+// openReader decrypts data with c. If data has an envelope, payload is the data
+// following it.
+func openReader(c keyedCipher, name string, data []byte, hasEnvelope bool, payload []byte) io.Reader {
+	var bout []byte
+	var err error
+	if hasEnvelope {
+		bout, err = c.open(name, data[:len(data)-len(payload)], payload)
+		if err != nil {
+			// the nonce of a legacy object may look like an envelope
+			if legacy, legacyErr := c.open(name, nil, data); legacyErr == nil {
+				bout, err = legacy, nil
+			}
+		}
+	} else {
+		bout, err = c.open(name, nil, data)
+	}
+
+	if err != nil {
+		return &Reader{nil, 0, err}
+	}
+	return bytes.NewReader(bout)
+}
+
+// open decrypts nonce||box, the name and header are not authenticated.
+func (sb *SecretBoxIO) open(_ string, _, data []byte) ([]byte, error) {
+	if len(data) < NonceSize {
+		return nil, errors.New("insufficient data for decryption: missing nonce")
 	}
 
 	var nonce [NonceSize]byte
-	copy(nonce[:], allData[:NonceSize])
-	encryptedData := allData[NonceSize:]
+	copy(nonce[:], data[:NonceSize])
+	encryptedData := data[NonceSize:]
 
 	bout, ok := secretbox.Open(nil, encryptedData, &nonce, &sb.SecretKey)
 	if !ok {
-		return &Reader{nil, 0, errors.New("decryption failed: invalid key or corrupted data")}
+		return nil, errors.New("decryption failed: invalid key or corrupted data")
 	}
-	return bytes.NewReader(bout)
+	return bout, nil
+}
+
+func (sb *SecretBoxIO) ByteReader(name string, msg []byte) Reader {
+	return sb.seal(name, msg, "")
 }
 
-func (sb *SecretBoxIO) ByteReader(msg []byte) Reader {
+func (sb *SecretBoxIO) seal(_ string, msg []byte, keyID string) Reader {
 	if !sb.IsValid() {
 		return Reader{nil, 0, errors.New("SecretBoxIO not properly initialized")}
 	}
@@ -112,8 +231,8 @@ func (sb *SecretBoxIO) ByteReader(msg []byte) Reader {
 		return Reader{nil, 0, err}
 	}
 
-	out := make([]byte, NonceSize, NonceSize+len(msg)+secretbox.Overhead)
-	copy(out, nonce[:])
+	out := AppendEnvelope(make([]byte, 0, envelopeLen(keyID)+NonceSize+len(msg)+secretbox.Overhead), AlgorithmSecretBox, keyID)
+	out = append(out, nonce[:]...)
 
 	out = secretbox.Seal(out, msg, &nonce, &sb.SecretKey)
 	return Reader{bytes.NewReader(out), int64(len(out)), nil}
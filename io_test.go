@@ -6,11 +6,14 @@ import (
 	"io"
 	"strings"
 	"testing"
+
+	"golang.org/x/crypto/nacl/secretbox"
 )
 
 var (
-	testKey32  = [32]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32}
-	testKeyStr = "12345678901234567890123456789012"
+	testKey32   = [32]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32}
+	testKeyStr  = "12345678901234567890123456789012"
+	testObjName = "acme/certificates/example.com/example.com.key"
 )
 
 func createTestSecretBoxIO() *SecretBoxIO {
@@ -75,13 +78,13 @@ func TestSecretBoxIO_Operations(t *testing.T) {
 	t.Run("encrypt decrypt roundtrip", func(t *testing.T) {
 		sb := createTestSecretBoxIO()
 		msg := []byte("This is a very important message that shall be encrypted...")
-		r := sb.ByteReader(msg)
+		r := sb.ByteReader(testObjName, msg)
 
 		buf, err := io.ReadAll(&r)
 		assertNoError(t, err, "encrypting")
 
 		w := bytes.NewReader(buf)
-		wb := sb.WrapReader(w)
+		wb := sb.WrapReader(testObjName, w)
 
 		buf, err = io.ReadAll(wb)
 		assertNoError(t, err, "decrypting")
@@ -91,17 +94,31 @@ func TestSecretBoxIO_Operations(t *testing.T) {
 		}
 	})
 
+	t.Run("legacy object without envelope", func(t *testing.T) {
+		sb := createTestSecretBoxIO()
+		msg := []byte("stored before the envelope was introduced")
+
+		var nonce [NonceSize]byte
+		legacy := secretbox.Seal(nonce[:], msg, &nonce, &sb.SecretKey)
+
+		buf, err := io.ReadAll(sb.WrapReader(testObjName, bytes.NewReader(legacy)))
+		assertNoError(t, err, "decrypting")
+		if !bytes.Equal(buf, msg) {
+			t.Errorf("did not decrypt, got: %s", buf)
+		}
+	})
+
 	t.Run("empty input handling", func(t *testing.T) {
 		sb := createTestSecretBoxIO()
 
-		wr := sb.WrapReader(bytes.NewReader(nil))
+		wr := sb.WrapReader(testObjName, bytes.NewReader(nil))
 		buf, err := io.ReadAll(wr)
 		assertNoError(t, err, "WrapReader with empty input")
 		if len(buf) != 0 {
 			t.Errorf("Buffer should be empty, got: %v", buf)
 		}
 
-		reader := sb.ByteReader([]byte{})
+		reader := sb.ByteReader(testObjName, []byte{})
 		_, err = io.ReadAll(&reader)
 		assertNoError(t, err, "ByteReader with empty input")
 	})
@@ -109,7 +126,7 @@ func TestSecretBoxIO_Operations(t *testing.T) {
 	t.Run("valid operations", func(t *testing.T) {
 		sb := createTestSecretBoxIO()
 
-		reader := sb.ByteReader([]byte("test message"))
+		reader := sb.ByteReader(testObjName, []byte("test message"))
 		_, err := io.ReadAll(&reader)
 		assertNoError(t, err, "ByteReader with valid input")
 	})
@@ -119,18 +136,25 @@ func TestSecretBoxIO_ErrorCases(t *testing.T) {
 	t.Run("uninitialized SecretBoxIO", func(t *testing.T) {
 		sb := &SecretBoxIO{}
 
-		wr := sb.WrapReader(bytes.NewReader([]byte("test")))
+		wr := sb.WrapReader(testObjName, bytes.NewReader([]byte("test")))
 		_, err := io.ReadAll(wr)
 		assertError(t, err, "not properly initialized", "WrapReader")
 
-		reader := sb.ByteReader([]byte("test"))
+		reader := sb.ByteReader(testObjName, []byte("test"))
 		_, err = io.ReadAll(&reader)
 		assertError(t, err, "not properly initialized", "ByteReader")
 	})
 
+	t.Run("envelope with another algorithm", func(t *testing.T) {
+		sb := createTestSecretBoxIO()
+		cleartext := (&CleartextIO{}).ByteReader(testObjName, []byte("test"))
+		_, err := io.ReadAll(sb.WrapReader(testObjName, &cleartext))
+		assertError(t, err, "uses none instead of secretbox", "decryption")
+	})
+
 	t.Run("insufficient data for decryption", func(t *testing.T) {
 		sb := &SecretBoxIO{SecretKey: testKey32}
-		wr := sb.WrapReader(bytes.NewReader([]byte("short"))) // Less than 24 bytes
+		wr := sb.WrapReader(testObjName, bytes.NewReader([]byte("short"))) // Less than 24 bytes
 		_, err := io.ReadAll(wr)
 		assertError(t, err, "insufficient data for decryption", "decryption")
 	})
@@ -140,24 +164,30 @@ func TestCleartextIO(t *testing.T) {
 	ci := &CleartextIO{}
 	testData := []byte("plain text data")
 
-	reader := ci.ByteReader(testData)
+	reader := ci.ByteReader(testObjName, testData)
 	result, err := io.ReadAll(&reader)
 	if err != nil {
 		t.Errorf("CleartextIO.ByteReader() error = %v", err)
 	}
-	if !bytes.Equal(result, testData) {
-		t.Errorf("CleartextIO.ByteReader() = %v, want %v", result, testData)
+	env, payload, ok := ParseEnvelope(result)
+	if !ok || env.Algorithm != AlgorithmNone || !bytes.Equal(payload, testData) {
+		t.Errorf("CleartextIO.ByteReader() = %v, want %v behind an envelope", result, testData)
 	}
 
-	input := bytes.NewReader(testData)
-	wrapped := ci.WrapReader(input)
-	result, err = io.ReadAll(wrapped)
-	if err != nil {
-		t.Errorf("CleartextIO.WrapReader() error = %v", err)
-	}
-	if !bytes.Equal(result, testData) {
-		t.Errorf("CleartextIO.WrapReader() = %v, want %v", result, testData)
+	for name, input := range map[string][]byte{"envelope": result, "legacy": testData} {
+		wrapped := ci.WrapReader(testObjName, bytes.NewReader(input))
+		result, err := io.ReadAll(wrapped)
+		if err != nil {
+			t.Errorf("CleartextIO.WrapReader() %s error = %v", name, err)
+		}
+		if !bytes.Equal(result, testData) {
+			t.Errorf("CleartextIO.WrapReader() %s = %v, want %v", name, result, testData)
+		}
 	}
+
+	encrypted := NewSecretBoxIO(testKey32).ByteReader(testObjName, testData)
+	_, err = io.ReadAll(ci.WrapReader(testObjName, &encrypted))
+	assertError(t, err, "encrypted with secretbox", "CleartextIO.WrapReader()")
 }
 
 func TestReader(t *testing.T) {
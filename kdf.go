@@ -0,0 +1,234 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	s3sdk "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Supported key derivation functions for EncryptionPassphrase.
+const (
+	KDFArgon2id = "argon2id"
+	KDFScrypt   = "scrypt"
+)
+
+// KDFParamsKey is the name of the object below Prefix that holds the salt and
+// parameters used to derive the encryption key from EncryptionPassphrase.
+const KDFParamsKey = ".encryption-kdf.json"
+
+const kdfSaltSize = 16
+
+// Bounds of the parameters read from the bucket, which anyone with write
+// access could otherwise raise to exhaust the memory of every node, or lower
+// to make the passphrase easy to brute-force. The defaults of NewKDFParams are
+// the lower bounds.
+const (
+	kdfMaxSaltSize = 64
+
+	argon2MinTime    = 3
+	argon2MaxTime    = 16
+	argon2MinMemory  = 64 * 1024 // KiB
+	argon2MaxMemory  = 1024 * 1024
+	argon2MinThreads = 1
+	argon2MaxThreads = 64
+
+	scryptMinN = 1 << 15
+	scryptMaxN = 1 << 20
+	scryptMinR = 8
+	scryptMaxR = 32
+	scryptMinP = 1
+	scryptMaxP = 16
+
+	// scryptMaxMemory bounds the memory used by scrypt, 128 * N * r bytes.
+	scryptMaxMemory = 1 << 30
+)
+
+// KDFParams records how the encryption key was derived, so that every node
+// derives the same key and the parameters can be upgraded later on.
+type KDFParams struct {
+	KDF  string `json:"kdf"`
+	Salt []byte `json:"salt"`
+
+	// Argon2id
+	Time    uint32 `json:"time,omitempty"`
+	Memory  uint32 `json:"memory,omitempty"`
+	Threads uint8  `json:"threads,omitempty"`
+
+	// scrypt
+	N int `json:"n,omitempty"`
+	R int `json:"r,omitempty"`
+	P int `json:"p,omitempty"`
+}
+
+// NewKDFParams returns the default parameters for kdf with a random salt.
+func NewKDFParams(kdf string) (*KDFParams, error) {
+	params := &KDFParams{KDF: kdf, Salt: make([]byte, kdfSaltSize)}
+	if _, err := io.ReadFull(rand.Reader, params.Salt); err != nil {
+		return nil, err
+	}
+
+	switch kdf {
+	case KDFArgon2id:
+		params.Time, params.Memory, params.Threads = 3, 64*1024, 4
+	case KDFScrypt:
+		params.N, params.R, params.P = 1<<15, 8, 1
+	default:
+		return nil, fmt.Errorf("unknown key derivation function: %s", kdf)
+	}
+	return params, nil
+}
+
+// validate checks that the parameters are within the supported bounds.
+func (p *KDFParams) validate() error {
+	if len(p.Salt) < kdfSaltSize || len(p.Salt) > kdfMaxSaltSize {
+		return fmt.Errorf("key derivation salt must have between %d and %d bytes", kdfSaltSize, kdfMaxSaltSize)
+	}
+
+	switch p.KDF {
+	case KDFArgon2id:
+		if p.Time < argon2MinTime || p.Time > argon2MaxTime ||
+			p.Memory < argon2MinMemory || p.Memory > argon2MaxMemory ||
+			p.Threads < argon2MinThreads || p.Threads > argon2MaxThreads {
+			return fmt.Errorf("invalid argon2id parameters: time %d, memory %d KiB, threads %d", p.Time, p.Memory, p.Threads)
+		}
+	case KDFScrypt:
+		if p.N < scryptMinN || p.N > scryptMaxN || p.N&(p.N-1) != 0 ||
+			p.R < scryptMinR || p.R > scryptMaxR ||
+			p.P < scryptMinP || p.P > scryptMaxP ||
+			128*p.N*p.R > scryptMaxMemory {
+			return fmt.Errorf("invalid scrypt parameters: N %d, r %d, p %d", p.N, p.R, p.P)
+		}
+	default:
+		return fmt.Errorf("unknown key derivation function: %s", p.KDF)
+	}
+	return nil
+}
+
+// DeriveKey derives a 32-byte key from passphrase.
+func (p *KDFParams) DeriveKey(passphrase string) ([32]byte, error) {
+	var key [32]byte
+	if err := p.validate(); err != nil {
+		return key, err
+	}
+
+	switch p.KDF {
+	case KDFArgon2id:
+		buf := argon2.IDKey([]byte(passphrase), p.Salt, p.Time, p.Memory, p.Threads, uint32(len(key)))
+		copy(key[:], buf)
+		clear(buf)
+	case KDFScrypt:
+		buf, err := scrypt.Key([]byte(passphrase), p.Salt, p.N, p.R, p.P, len(key))
+		if err != nil {
+			return key, fmt.Errorf("invalid scrypt parameters: %w", err)
+		}
+		copy(key[:], buf)
+		clear(buf)
+	default:
+		return key, fmt.Errorf("unknown key derivation function: %s", p.KDF)
+	}
+	return key, nil
+}
+
+// passphraseKey derives the encryption key from EncryptionPassphrase, using the
+// parameters stored in the bucket or creating them on first use.
+func (s3 *S3) passphraseKey(ctx context.Context) ([32]byte, error) {
+	params, err := s3.loadKDFParams(ctx)
+	if errors.Is(err, errKDFParamsNotFound) {
+		params, err = s3.createKDFParams(ctx)
+	}
+	if err != nil {
+		return [32]byte{}, err
+	}
+
+	if s3.EncryptionKDF != "" && s3.EncryptionKDF != params.KDF {
+		s3.Logger.Warn("configured key derivation function differs from the one recorded in the bucket, using the recorded one",
+			zap.String("configured", s3.EncryptionKDF),
+			zap.String("recorded", params.KDF),
+		)
+	}
+	return params.DeriveKey(s3.EncryptionPassphrase)
+}
+
+var errKDFParamsNotFound = errors.New("key derivation parameters not found")
+
+func (s3 *S3) loadKDFParams(ctx context.Context) (*KDFParams, error) {
+	input := &s3sdk.GetObjectInput{
+		Bucket: aws.String(s3.Bucket),
+		Key:    aws.String(s3.objName(KDFParamsKey)),
+	}
+	s3.applySSEGet(input)
+
+	result, err := s3.Client.GetObject(ctx, input)
+	if err != nil {
+		var nsk *types.NoSuchKey
+		if errors.As(err, &nsk) {
+			return nil, errKDFParamsNotFound
+		}
+		return nil, fmt.Errorf("failed to load key derivation parameters: %w", err)
+	}
+	defer func() { _ = result.Body.Close() }()
+
+	var params KDFParams
+	if err := json.NewDecoder(result.Body).Decode(&params); err != nil {
+		return nil, fmt.Errorf("failed to decode key derivation parameters: %w", err)
+	}
+	if err := params.validate(); err != nil {
+		return nil, fmt.Errorf("rejected key derivation parameters in %s: %w", s3.objName(KDFParamsKey), err)
+	}
+	return &params, nil
+}
+
+func (s3 *S3) createKDFParams(ctx context.Context) (*KDFParams, error) {
+	kdf := s3.EncryptionKDF
+	if kdf == "" {
+		kdf = KDFArgon2id
+	}
+	params, err := NewKDFParams(kdf)
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &s3sdk.PutObjectInput{
+		Bucket:        aws.String(s3.Bucket),
+		Key:           aws.String(s3.objName(KDFParamsKey)),
+		Body:          bytes.NewReader(buf),
+		ContentLength: aws.Int64(int64(len(buf))),
+		// another node may be creating the parameters at the same time
+		IfNoneMatch: aws.String("*"),
+	}
+	s3.applySSEPut(input)
+
+	_, err = s3.Client.PutObject(ctx, input)
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "PreconditionFailed" {
+		return s3.loadKDFParams(ctx)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to store key derivation parameters: %w", err)
+	}
+
+	s3.Logger.Info("created key derivation parameters",
+		zap.String("key", s3.objName(KDFParamsKey)),
+		zap.String("kdf", params.KDF),
+	)
+	// some S3-compatible providers ignore If-None-Match, so another node may
+	// have replaced the parameters, and the stored ones are used by every node
+	return s3.loadKDFParams(ctx)
+}
@@ -0,0 +1,139 @@
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	s3sdk "github.com/aws/aws-sdk-go-v2/service/s3"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+func TestKDFParams_DeriveKey(t *testing.T) {
+	for _, kdf := range []string{KDFArgon2id, KDFScrypt} {
+		t.Run(kdf, func(t *testing.T) {
+			params, err := NewKDFParams(kdf)
+			assertNoError(t, err, "NewKDFParams()")
+
+			key1, err := params.DeriveKey("correct horse battery staple")
+			assertNoError(t, err, "DeriveKey()")
+			key2, err := params.DeriveKey("correct horse battery staple")
+			assertNoError(t, err, "DeriveKey()")
+			if key1 != key2 {
+				t.Error("DeriveKey() is not deterministic")
+			}
+
+			other, err := params.DeriveKey("another passphrase")
+			assertNoError(t, err, "DeriveKey()")
+			if key1 == other {
+				t.Error("DeriveKey() returned the same key for different passphrases")
+			}
+		})
+	}
+
+	t.Run("unknown kdf", func(t *testing.T) {
+		_, err := NewKDFParams("md5")
+		assertError(t, err, "unknown key derivation function", "NewKDFParams()")
+	})
+
+	t.Run("bounds", func(t *testing.T) {
+		salt := make([]byte, kdfSaltSize)
+		tests := []struct {
+			name    string
+			params  KDFParams
+			wantErr string
+		}{
+			{name: "short salt", params: KDFParams{KDF: KDFScrypt, Salt: []byte("salt"), N: 1 << 15, R: 8, P: 1}, wantErr: "salt must have"},
+			{name: "long salt", params: KDFParams{KDF: KDFScrypt, Salt: make([]byte, 65), N: 1 << 15, R: 8, P: 1}, wantErr: "salt must have"},
+			{name: "weak argon2id", params: KDFParams{KDF: KDFArgon2id, Salt: salt, Time: 1, Memory: 1, Threads: 1}, wantErr: "invalid argon2id parameters"},
+			{name: "huge argon2id memory", params: KDFParams{KDF: KDFArgon2id, Salt: salt, Time: 3, Memory: 1 << 31, Threads: 4}, wantErr: "invalid argon2id parameters"},
+			{name: "no argon2id threads", params: KDFParams{KDF: KDFArgon2id, Salt: salt, Time: 3, Memory: 64 * 1024}, wantErr: "invalid argon2id parameters"},
+			{name: "weak scrypt", params: KDFParams{KDF: KDFScrypt, Salt: salt, N: 1 << 10, R: 8, P: 1}, wantErr: "invalid scrypt parameters"},
+			{name: "huge scrypt N", params: KDFParams{KDF: KDFScrypt, Salt: salt, N: 1 << 40, R: 8, P: 1}, wantErr: "invalid scrypt parameters"},
+			{name: "scrypt N not a power of two", params: KDFParams{KDF: KDFScrypt, Salt: salt, N: 1<<15 + 1, R: 8, P: 1}, wantErr: "invalid scrypt parameters"},
+			{name: "scrypt memory", params: KDFParams{KDF: KDFScrypt, Salt: salt, N: 1 << 20, R: 32, P: 1}, wantErr: "invalid scrypt parameters"},
+			{name: "scrypt p", params: KDFParams{KDF: KDFScrypt, Salt: salt, N: 1 << 15, R: 8, P: 1000}, wantErr: "invalid scrypt parameters"},
+		}
+		for _, tt := range tests {
+			_, err := tt.params.DeriveKey("passphrase")
+			assertError(t, err, tt.wantErr, "DeriveKey() with "+tt.name)
+		}
+	})
+}
+
+func TestS3_loadKDFParams(t *testing.T) {
+	ctx := context.Background()
+	s3, fake := newTestS3(t)
+	fake.put(s3.objName(KDFParamsKey), []byte(`{"kdf":"argon2id","salt":"AAAAAAAAAAAAAAAAAAAAAA==","time":1,"memory":1,"threads":1}`))
+
+	_, err := s3.loadKDFParams(ctx)
+	assertError(t, err, "rejected key derivation parameters", "loadKDFParams()")
+}
+
+func TestS3_passphraseKey(t *testing.T) {
+	ctx := context.Background()
+	first, fake := newTestS3(t)
+	first.EncryptionPassphrase = "correct horse battery staple"
+	first.EncryptionKDF = KDFScrypt
+
+	key1, err := first.passphraseKey(ctx)
+	assertNoError(t, err, "passphraseKey()")
+
+	stored, ok := fake.get(first.objName(KDFParamsKey))
+	if !ok {
+		t.Fatal("key derivation parameters were not stored")
+	}
+	var params KDFParams
+	assertNoError(t, json.Unmarshal(stored, &params), "decoding parameters")
+	if params.KDF != KDFScrypt {
+		t.Errorf("stored KDF = %q, want %q", params.KDF, KDFScrypt)
+	}
+
+	// a second node with a different configured KDF uses the recorded parameters
+	second := *first
+	second.EncryptionKDF = KDFArgon2id
+	key2, err := second.passphraseKey(ctx)
+	assertNoError(t, err, "passphraseKey()")
+	if key1 != key2 {
+		t.Error("nodes sharing a bucket derived different keys")
+	}
+
+	// creating the parameters again must not replace the recorded ones
+	recreated, err := first.createKDFParams(ctx)
+	assertNoError(t, err, "createKDFParams()")
+	if string(recreated.Salt) != string(params.Salt) {
+		t.Error("createKDFParams() replaced existing parameters")
+	}
+}
+
+func TestS3_createKDFParams(t *testing.T) {
+	ctx := context.Background()
+	s3, fake := newTestS3(t)
+	s3.EncryptionPassphrase = "correct horse battery staple"
+
+	// another node replaces the parameters right after they were created, as
+	// with providers that ignore If-None-Match
+	concurrent, err := NewKDFParams(KDFScrypt)
+	assertNoError(t, err, "NewKDFParams()")
+	buf, err := json.Marshal(concurrent)
+	assertNoError(t, err, "json.Marshal()")
+	next := s3.Client.Options().HTTPClient
+	s3.Client = s3sdk.New(s3.Client.Options(), func(o *s3sdk.Options) {
+		o.HTTPClient = smithyhttp.ClientDoFunc(func(r *http.Request) (*http.Response, error) {
+			resp, err := next.Do(r)
+			if r.Method == http.MethodPut {
+				fake.put(s3.objName(KDFParamsKey), buf)
+			}
+			return resp, err
+		})
+	})
+
+	key, err := s3.passphraseKey(ctx)
+	assertNoError(t, err, "passphraseKey()")
+	want, err := concurrent.DeriveKey(s3.EncryptionPassphrase)
+	assertNoError(t, err, "DeriveKey()")
+	if key != want {
+		t.Error("passphraseKey() did not derive the key from the stored parameters")
+	}
+}
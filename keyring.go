@@ -0,0 +1,158 @@
+package s3
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// KeyringIO encrypts with the primary key of a set of named keys. The envelope
+// of every object holds the ID of the key and the algorithm it was encrypted
+// with, so objects written with older keys or algorithms remain readable after
+// the primary key or the algorithm is changed. Objects without a key ID, as
+// written by SecretBoxIO, are decrypted by trying each key. Unless the keyring
+// encrypts with secretbox itself, secretbox objects are only decrypted after
+// AllowLegacySecretBox is called, as they are not bound to their name.
+type KeyringIO struct {
+	keys      map[string][32]byte
+	primary   string
+	algorithm Algorithm
+	legacy    bool
+}
+
+// NewKeyringIO returns a KeyringIO that encrypts new objects with the key named
+// primary using algorithm.
+func NewKeyringIO(keys map[string][32]byte, primary string, algorithm Algorithm) (*KeyringIO, error) {
+	if _, ok := keys[primary]; !ok {
+		return nil, fmt.Errorf("primary encryption key %q is not in the keyring", primary)
+	}
+	if _, err := newKeyedCipher(algorithm, keys[primary]); err != nil {
+		return nil, err
+	}
+
+	kr := &KeyringIO{keys: make(map[string][32]byte, len(keys)), primary: primary, algorithm: algorithm}
+	for id, key := range keys {
+		if id == "" || len(id) > maxKeyIDLength {
+			return nil, fmt.Errorf("encryption key ID must have between 1 and %d bytes", maxKeyIDLength)
+		}
+		kr.keys[id] = key
+	}
+	return kr, nil
+}
+
+func (kr *KeyringIO) WrapReader(name string, r io.Reader) io.Reader {
+	if kr.keys[kr.primary] == ([32]byte{}) {
+		return &Reader{nil, 0, errKeyWiped}
+	}
+
+	env, header, br, ok, err := peekEnvelope(r)
+	if err != nil {
+		return &Reader{nil, 0, err}
+	}
+	if ok && env.Version == EnvelopeVersionStream {
+		if key, found := kr.keys[env.KeyID]; found {
+			c, err := newKeyedCipher(env.Algorithm, key)
+			if err != nil {
+				return &Reader{nil, 0, fmt.Errorf("decryption failed: %w", err)}
+			}
+			if sc, ok := c.(streamCipher); ok {
+				_, _ = br.Discard(len(header))
+				return sc.openStream(name, header, br)
+			}
+		}
+	}
+
+	allData, err := io.ReadAll(br)
+	if err != nil {
+		return &Reader{nil, 0, err}
+	}
+
+	if len(allData) == 0 {
+		return bytes.NewReader(nil)
+	}
+
+	env, payload, ok := ParseEnvelope(allData)
+	if ok && env.Algorithm == AlgorithmSecretBox && !kr.readsSecretBox() {
+		return &Reader{nil, 0, errUnboundObject}
+	}
+	if ok {
+		if key, found := kr.keys[env.KeyID]; found {
+			c, err := newKeyedCipher(env.Algorithm, key)
+			if err != nil {
+				return &Reader{nil, 0, fmt.Errorf("decryption failed: %w", err)}
+			}
+			return openReader(c, name, allData, ok, payload)
+		}
+		if env.KeyID == "" {
+			header := allData[:len(allData)-len(payload)]
+			if buf, err := kr.tryKeys(name, env.Algorithm, header, payload); err == nil {
+				return bytes.NewReader(buf)
+			}
+		}
+	}
+
+	if !kr.readsSecretBox() {
+		if !ok {
+			return &Reader{nil, 0, errUnboundObject}
+		}
+		if env.KeyID != "" {
+			return &Reader{nil, 0, fmt.Errorf("decryption failed: unknown encryption key %q", env.KeyID)}
+		}
+		return &Reader{nil, 0, errors.New("decryption failed: invalid key or corrupted data")}
+	}
+
+	// legacy object written by SecretBoxIO, the nonce of which may even look like an envelope
+	buf, err := kr.tryKeys(name, AlgorithmSecretBox, nil, allData)
+	if err != nil && ok && env.KeyID != "" {
+		return &Reader{nil, 0, fmt.Errorf("decryption failed: unknown encryption key %q", env.KeyID)}
+	}
+	if err != nil {
+		return &Reader{nil, 0, err}
+	}
+	return bytes.NewReader(buf)
+}
+
+// AllowLegacySecretBox enables decrypting objects written by SecretBoxIO or
+// by a keyring encrypting with secretbox. It should only be enabled until
+// these objects are migrated.
+func (kr *KeyringIO) AllowLegacySecretBox() {
+	kr.legacy = true
+}
+
+// readsSecretBox reports whether objects encrypted with secretbox are decrypted.
+func (kr *KeyringIO) readsSecretBox() bool {
+	return kr.legacy || kr.algorithm == AlgorithmSecretBox
+}
+
+// tryKeys decrypts payload with each key of the keyring.
+func (kr *KeyringIO) tryKeys(name string, algorithm Algorithm, header, payload []byte) ([]byte, error) {
+	for _, key := range kr.keys {
+		c, err := newKeyedCipher(algorithm, key)
+		if err != nil {
+			return nil, fmt.Errorf("decryption failed: %w", err)
+		}
+
+		if buf, err := c.open(name, header, payload); err == nil {
+			return buf, nil
+		}
+	}
+	return nil, errors.New("decryption failed: invalid key or corrupted data")
+}
+
+func (kr *KeyringIO) ByteReader(name string, msg []byte) Reader {
+	if kr.keys[kr.primary] == ([32]byte{}) {
+		return Reader{nil, 0, errKeyWiped}
+	}
+	c, err := newKeyedCipher(kr.algorithm, kr.keys[kr.primary])
+	if err != nil {
+		return Reader{nil, 0, err}
+	}
+	return c.seal(name, msg, kr.primary)
+}
+
+func (kr *KeyringIO) wipe() {
+	for id := range kr.keys {
+		kr.keys[id] = [32]byte{}
+	}
+}
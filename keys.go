@@ -0,0 +1,91 @@
+package s3
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+)
+
+// Supported encodings of the encryption key.
+const (
+	KeyEncodingAuto   = "auto"
+	KeyEncodingRaw    = "raw"
+	KeyEncodingHex    = "hex"
+	KeyEncodingBase64 = "base64"
+)
+
+// decodeEncryptionKey decodes key according to encoding and ensures the result
+// has exactly 32 bytes. With the auto encoding (or an empty encoding), hex and
+// base64 are detected by the length of the key, everything else is used raw.
+func decodeEncryptionKey(key, encoding string) ([32]byte, error) {
+	var out [32]byte
+
+	if encoding == "" || encoding == KeyEncodingAuto {
+		encoding = detectKeyEncoding(key)
+	}
+
+	var buf []byte
+	switch encoding {
+	case KeyEncodingRaw:
+		buf = []byte(key)
+	case KeyEncodingHex:
+		decoded, err := hex.DecodeString(key)
+		if err != nil {
+			return out, fmt.Errorf("invalid hex encryption key: %w", err)
+		}
+		buf = decoded
+	case KeyEncodingBase64:
+		decoded, err := decodeBase64(key)
+		if err != nil {
+			return out, fmt.Errorf("invalid base64 encryption key: %w", err)
+		}
+		buf = decoded
+	default:
+		return out, fmt.Errorf("unknown encryption key encoding: %s", encoding)
+	}
+
+	defer clear(buf)
+
+	if len(buf) != len(out) {
+		return out, fmt.Errorf("encryption key must have exactly 32 bytes, got %d", len(buf))
+	}
+	copy(out[:], buf)
+	return out, nil
+}
+
+func detectKeyEncoding(key string) string {
+	switch len(key) {
+	case hex.EncodedLen(32):
+		if _, err := hex.DecodeString(key); err == nil {
+			return KeyEncodingHex
+		}
+	case base64.StdEncoding.EncodedLen(32), base64.RawStdEncoding.EncodedLen(32):
+		if _, err := decodeBase64(key); err == nil {
+			return KeyEncodingBase64
+		}
+	}
+	return KeyEncodingRaw
+}
+
+// decodeBase64 accepts standard and URL-safe base64, with or without padding.
+func decodeBase64(s string) ([]byte, error) {
+	var err error
+	for _, enc := range []*base64.Encoding{
+		base64.StdEncoding, base64.RawStdEncoding,
+		base64.URLEncoding, base64.RawURLEncoding,
+	} {
+		var buf []byte
+		if buf, err = enc.DecodeString(s); err == nil {
+			return buf, nil
+		}
+	}
+	return nil, err
+}
+
+func isValidKeyEncoding(encoding string) bool {
+	switch encoding {
+	case "", KeyEncodingAuto, KeyEncodingRaw, KeyEncodingHex, KeyEncodingBase64:
+		return true
+	}
+	return false
+}
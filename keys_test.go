@@ -0,0 +1,80 @@
+package s3
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+)
+
+func TestDecodeEncryptionKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		key      string
+		encoding string
+		wantErr  string
+	}{
+		{
+			name: "raw key auto detected",
+			key:  testKeyStr,
+		},
+		{
+			name: "hex key auto detected",
+			key:  hex.EncodeToString([]byte(testKeyStr)),
+		},
+		{
+			name: "base64 key auto detected",
+			key:  base64.StdEncoding.EncodeToString([]byte(testKeyStr)),
+		},
+		{
+			name: "unpadded url-safe base64 key auto detected",
+			key:  base64.RawURLEncoding.EncodeToString([]byte(testKeyStr)),
+		},
+		{
+			name:     "explicit base64",
+			key:      base64.StdEncoding.EncodeToString([]byte(testKeyStr)),
+			encoding: KeyEncodingBase64,
+		},
+		{
+			name:     "explicit raw",
+			key:      testKeyStr,
+			encoding: KeyEncodingRaw,
+		},
+		{
+			name:    "wrong length",
+			key:     "too short",
+			wantErr: "exactly 32 bytes",
+		},
+		{
+			name:     "invalid hex",
+			key:      "not a hex key",
+			encoding: KeyEncodingHex,
+			wantErr:  "invalid hex",
+		},
+		{
+			name:     "hex with wrong decoded length",
+			key:      hex.EncodeToString([]byte("short")),
+			encoding: KeyEncodingHex,
+			wantErr:  "exactly 32 bytes",
+		},
+		{
+			name:     "unknown encoding",
+			key:      testKeyStr,
+			encoding: "rot13",
+			wantErr:  "unknown encryption key encoding",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := decodeEncryptionKey(tt.key, tt.encoding)
+			if tt.wantErr != "" {
+				assertError(t, err, tt.wantErr, "decodeEncryptionKey()")
+				return
+			}
+			assertNoError(t, err, "decodeEncryptionKey()")
+			if string(key[:]) != testKeyStr {
+				t.Errorf("decodeEncryptionKey() = %q, want %q", key, testKeyStr)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package s3
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+// KMSClient is the subset of the AWS KMS API used by KMSIO.
+type KMSClient interface {
+	GenerateDataKey(ctx context.Context, params *kms.GenerateDataKeyInput, optFns ...func(*kms.Options)) (*kms.GenerateDataKeyOutput, error)
+	Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error)
+}
+
+// KMSIO implements envelope encryption with AWS KMS: every object is encrypted
+// with its own data key, which is generated by KMS and stored in the object
+// wrapped by the KMS key. The object name is passed to KMS as encryption context.
+type KMSIO struct {
+	dataKeyIO
+}
+
+// NewKMSIO returns a KMSIO that wraps data keys with the KMS key keyID. Unwrapped
+// data keys are cached for cacheTTL, caching is disabled if it is not positive.
+func NewKMSIO(client KMSClient, keyID string, cacheTTL time.Duration) *KMSIO {
+	return &KMSIO{newDataKeyIO(AlgorithmKMS, &kmsProvider{client, keyID}, cacheTTL)}
+}
+
+type kmsProvider struct {
+	client KMSClient
+	keyID  string
+}
+
+func (p *kmsProvider) generateDataKey(ctx context.Context, name string) ([]byte, []byte, error) {
+	result, err := p.client.GenerateDataKey(ctx, &kms.GenerateDataKeyInput{
+		KeyId:             aws.String(p.keyID),
+		KeySpec:           kmstypes.DataKeySpecAes256,
+		EncryptionContext: kmsEncryptionContext(name),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return result.Plaintext, result.CiphertextBlob, nil
+}
+
+func (p *kmsProvider) unwrapDataKey(ctx context.Context, name string, wrapped []byte) ([]byte, error) {
+	result, err := p.client.Decrypt(ctx, &kms.DecryptInput{
+		CiphertextBlob:    wrapped,
+		KeyId:             aws.String(p.keyID),
+		EncryptionContext: kmsEncryptionContext(name),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.Plaintext, nil
+}
+
+func kmsEncryptionContext(name string) map[string]string {
+	return map[string]string{"certmagic-s3:object": name}
+}
+
+var _ IO = (*KMSIO)(nil)
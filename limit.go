@@ -0,0 +1,148 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	s3sdk "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+	"golang.org/x/time/rate"
+)
+
+// Budgets of S3.RequestLimits. Lock polling is limited separately, so that
+// waiting for locks does not slow down loading certificates.
+const (
+	BudgetRead  = "read"
+	BudgetWrite = "write"
+	BudgetLock  = "lock"
+)
+
+// RequestLimit bounds the requests sent to S3 for one budget.
+type RequestLimit struct {
+	// Rate is the number of requests per second, and Burst how many requests
+	// may be sent at once after being idle (defaults to Rate rounded up).
+	Rate  float64 `json:"rate,omitempty"`
+	Burst int     `json:"burst,omitempty"`
+
+	// MaxInFlight is the number of requests that may wait for a response at
+	// the same time.
+	MaxInFlight int `json:"max_in_flight,omitempty"`
+}
+
+func isValidBudget(budget string) bool {
+	return budget == BudgetRead || budget == BudgetWrite || budget == BudgetLock
+}
+
+// requestLimiter enforces a RequestLimit.
+type requestLimiter struct {
+	rate     *rate.Limiter
+	inFlight chan struct{}
+}
+
+func newRequestLimiter(limit *RequestLimit) *requestLimiter {
+	l := new(requestLimiter)
+	if limit.Rate > 0 {
+		burst := limit.Burst
+		if burst == 0 {
+			burst = int(math.Ceil(limit.Rate))
+		}
+		l.rate = rate.NewLimiter(rate.Limit(limit.Rate), burst)
+	}
+	if limit.MaxInFlight > 0 {
+		l.inFlight = make(chan struct{}, limit.MaxInFlight)
+	}
+	return l
+}
+
+// acquire waits until a request may be sent. release must be called once
+// the response was received.
+func (l *requestLimiter) acquire(ctx context.Context) (release func(), err error) {
+	if l.inFlight != nil {
+		select {
+		case l.inFlight <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	release = func() {
+		if l.inFlight != nil {
+			<-l.inFlight
+		}
+	}
+
+	if l.rate != nil {
+		if err := l.rate.Wait(ctx); err != nil {
+			release()
+			return nil, err
+		}
+	}
+	return release, nil
+}
+
+func (s3 *S3) setupRequestLimits() error {
+	if len(s3.RequestLimits) == 0 {
+		return nil
+	}
+
+	s3.limiters = make(map[string]*requestLimiter, len(s3.RequestLimits))
+	for budget, limit := range s3.RequestLimits {
+		if !isValidBudget(budget) {
+			return fmt.Errorf("unknown request budget: %s", budget)
+		}
+		if limit == nil {
+			continue
+		}
+		if limit.Rate < 0 || limit.Burst < 0 || limit.MaxInFlight < 0 {
+			return fmt.Errorf("request limits for %s must not be negative", budget)
+		}
+		if limit.Burst > 0 && limit.Rate == 0 {
+			return fmt.Errorf("burst for %s requires a rate", budget)
+		}
+		s3.limiters[budget] = newRequestLimiter(limit)
+	}
+	return nil
+}
+
+// withRequestLimits adds the request limits to the options of an S3 client.
+func (s3 *S3) withRequestLimits(o *s3sdk.Options) {
+	o.APIOptions = append(o.APIOptions, s3.addLimitMiddleware)
+}
+
+// addLimitMiddleware limits every attempt of a request, after retries.
+func (s3 *S3) addLimitMiddleware(stack *middleware.Stack) error {
+	return stack.Finalize.Insert(middleware.FinalizeMiddlewareFunc("RequestLimit",
+		func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+			l := s3.limiters[requestBudget(ctx)]
+			if l == nil {
+				return next.HandleFinalize(ctx, in)
+			}
+			release, err := l.acquire(ctx)
+			if err != nil {
+				return middleware.FinalizeOutput{}, middleware.Metadata{}, fmt.Errorf("request limit: %w", err)
+			}
+			defer release()
+			return next.HandleFinalize(ctx, in)
+		}), "Retry", middleware.After)
+}
+
+type budgetKey struct{}
+
+// withLockBudget makes the requests sent with ctx count against the lock budget.
+func withLockBudget(ctx context.Context) context.Context {
+	return context.WithValue(ctx, budgetKey{}, BudgetLock)
+}
+
+// requestBudget returns the budget of the request sent with ctx.
+func requestBudget(ctx context.Context) string {
+	if budget, ok := ctx.Value(budgetKey{}).(string); ok {
+		return budget
+	}
+	op := awsmiddleware.GetOperationName(ctx)
+	if strings.HasPrefix(op, "Get") || strings.HasPrefix(op, "Head") || strings.HasPrefix(op, "List") {
+		return BudgetRead
+	}
+	return BudgetWrite
+}
@@ -0,0 +1,105 @@
+package s3
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	s3sdk "github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// newLimitedTestS3 returns a test storage with limits.
+func newLimitedTestS3(t *testing.T, limits map[string]*RequestLimit) (*S3, *fakeS3) {
+	s3, fake := newTestS3(t)
+	s3.RequestLimits = limits
+	assertNoError(t, s3.setupRequestLimits(), "setupRequestLimits()")
+	s3.Client = s3sdk.New(s3.Client.Options(), s3.withRequestLimits)
+	return s3, fake
+}
+
+func TestS3_RequestLimits(t *testing.T) {
+	ctx := context.Background()
+	cert := []byte("-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n")
+
+	t.Run("max in flight", func(t *testing.T) {
+		s3, fake := newLimitedTestS3(t, map[string]*RequestLimit{BudgetRead: {MaxInFlight: 1}})
+		assertNoError(t, s3.Store(ctx, "a.crt", cert), "Store()")
+		assertNoError(t, s3.Store(ctx, "b.crt", cert), "Store()")
+
+		release := fake.block()
+		var wg sync.WaitGroup
+		for _, key := range []string{"a.crt", "b.crt"} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := s3.Load(ctx, key)
+				assertNoError(t, err, "Load()")
+			}()
+		}
+		waitForRequests(t, fake, http.MethodGet, 1)
+
+		time.Sleep(50 * time.Millisecond)
+		if n := fake.count(http.MethodGet); n != 1 {
+			t.Errorf("sent %d concurrent GET requests, want 1", n)
+		}
+		release()
+		wg.Wait()
+	})
+
+	t.Run("rate", func(t *testing.T) {
+		s3, _ := newLimitedTestS3(t, map[string]*RequestLimit{BudgetWrite: {Rate: 20, Burst: 1}})
+		start := time.Now()
+		for range 3 {
+			assertNoError(t, s3.Store(ctx, "a.crt", cert), "Store()")
+		}
+		if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+			t.Errorf("3 writes at 20/s took %v", elapsed)
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		s3, _ := newLimitedTestS3(t, map[string]*RequestLimit{BudgetLock: {Rate: 0.001, Burst: 2}})
+		// checks for and creates the lock file
+		assertNoError(t, s3.Lock(ctx, "a.crt"), "Lock()")
+
+		timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		err := s3.Unlock(timeout, "a.crt")
+		assertError(t, err, "request limit", "Unlock()")
+	})
+}
+
+func TestRequestBudget(t *testing.T) {
+	if budget := requestBudget(withLockBudget(context.Background())); budget != BudgetLock {
+		t.Errorf("requestBudget() = %s, want %s", budget, BudgetLock)
+	}
+}
+
+func TestS3_setupRequestLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		limits  map[string]*RequestLimit
+		wantErr string
+	}{
+		{name: "valid", limits: map[string]*RequestLimit{BudgetRead: {Rate: 10, MaxInFlight: 4}, BudgetLock: {Rate: 1}}},
+		{name: "unknown budget", limits: map[string]*RequestLimit{"list": {Rate: 1}}, wantErr: "unknown request budget"},
+		{name: "negative", limits: map[string]*RequestLimit{BudgetWrite: {MaxInFlight: -1}}, wantErr: "must not be negative"},
+		{name: "burst without rate", limits: map[string]*RequestLimit{BudgetWrite: {Burst: 5}}, wantErr: "requires a rate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s3 := &S3{RequestLimits: tt.limits}
+			err := s3.setupRequestLimits()
+			if tt.wantErr != "" {
+				assertError(t, err, tt.wantErr, "setupRequestLimits()")
+				return
+			}
+			assertNoError(t, err, "setupRequestLimits()")
+			if len(s3.limiters) != len(tt.limits) {
+				t.Errorf("got %d limiters, want %d", len(s3.limiters), len(tt.limits))
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"sync"
+	"time"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/smithy-go"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Operations of the storage metrics.
+const (
+	opStore  = "store"
+	opLoad   = "load"
+	opDelete = "delete"
+	opExists = "exists"
+	opStat   = "stat"
+	opList   = "list"
+	opLock   = "lock"
+	opUnlock = "unlock"
+)
+
+// storageCollectors are shared by all S3 storages, which are distinguished
+// by the bucket label, and kept across config reloads.
+var storageCollectors = struct {
+	once        sync.Once
+	operations  *prometheus.CounterVec
+	errors      *prometheus.CounterVec
+	duration    *prometheus.HistogramVec
+	bytes       *prometheus.CounterVec
+	lockWait    *prometheus.HistogramVec
+	lockContend *prometheus.CounterVec
+	cleartext   *prometheus.CounterVec
+}{}
+
+func initStorageCollectors() {
+	const ns, sub = "caddy", "storage_s3"
+
+	storageCollectors.once.Do(func() {
+		labels := []string{"bucket", "operation"}
+		storageCollectors.operations = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Subsystem: sub,
+			Name:      "operations_total",
+			Help:      "Number of storage operations.",
+		}, labels)
+		storageCollectors.errors = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Subsystem: sub,
+			Name:      "operation_errors_total",
+			Help:      "Number of failed storage operations by error class.",
+		}, append(labels, "error"))
+		storageCollectors.duration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: ns,
+			Subsystem: sub,
+			Name:      "operation_duration_seconds",
+			Help:      "Histogram of storage operation durations.",
+			Buckets:   prometheus.DefBuckets,
+		}, labels)
+		storageCollectors.bytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Subsystem: sub,
+			Name:      "bytes_total",
+			Help:      "Number of bytes of values stored and loaded, before encryption.",
+		}, labels)
+		storageCollectors.lockWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: ns,
+			Subsystem: sub,
+			Name:      "lock_wait_seconds",
+			Help:      "Histogram of the time spent acquiring locks.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 4, 8),
+		}, []string{"bucket"})
+		storageCollectors.lockContend = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Subsystem: sub,
+			Name:      "lock_contention_total",
+			Help:      "Number of lock acquisitions that had to wait for another holder.",
+		}, []string{"bucket"})
+		storageCollectors.cleartext = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Subsystem: sub,
+			Name:      "cleartext_objects_total",
+			Help:      "Number of unencrypted objects loaded and rewritten with cleartext_reads.",
+		}, []string{"bucket", "action"})
+	})
+}
+
+// storageMetrics records the metrics of one S3 storage. Its methods do
+// nothing on a nil receiver, e.g. if the storage was not provisioned.
+type storageMetrics struct {
+	operations  *prometheus.CounterVec
+	errors      *prometheus.CounterVec
+	duration    prometheus.ObserverVec
+	bytes       *prometheus.CounterVec
+	lockWait    prometheus.Observer
+	lockContend prometheus.Counter
+	cleartext   *prometheus.CounterVec
+}
+
+// newStorageMetrics registers the collectors with registry, and returns the
+// metrics of bucket.
+func newStorageMetrics(registry prometheus.Registerer, bucket string) (*storageMetrics, error) {
+	initStorageCollectors()
+
+	c := &storageCollectors
+	for _, collector := range []prometheus.Collector{c.operations, c.errors, c.duration, c.bytes, c.lockWait, c.lockContend, c.cleartext} {
+		// multiple storages register the same collectors
+		if err := registry.Register(collector); err != nil && !errors.As(err, new(prometheus.AlreadyRegisteredError)) {
+			return nil, err
+		}
+	}
+
+	labels := prometheus.Labels{"bucket": bucket}
+	return &storageMetrics{
+		operations:  c.operations.MustCurryWith(labels),
+		errors:      c.errors.MustCurryWith(labels),
+		duration:    c.duration.MustCurryWith(labels),
+		bytes:       c.bytes.MustCurryWith(labels),
+		lockWait:    c.lockWait.With(labels),
+		lockContend: c.lockContend.With(labels),
+		cleartext:   c.cleartext.MustCurryWith(labels),
+	}, nil
+}
+
+// observe records an operation that started at start, and either transferred
+// size bytes or failed with err if it is not nil.
+func (m *storageMetrics) observe(operation string, start time.Time, size int, err error) {
+	if m == nil {
+		return
+	}
+	m.operations.WithLabelValues(operation).Inc()
+	m.duration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		m.errors.WithLabelValues(operation, errorClass(err)).Inc()
+	} else if size > 0 {
+		m.bytes.WithLabelValues(operation).Add(float64(size))
+	}
+}
+
+// observeLock records the time spent acquiring a lock, and whether it was
+// held by someone else.
+func (m *storageMetrics) observeLock(start time.Time, contended bool) {
+	if m == nil {
+		return
+	}
+	m.lockWait.Observe(time.Since(start).Seconds())
+	if contended {
+		m.lockContend.Inc()
+	}
+}
+
+// observeCleartext records that an unencrypted object was loaded, or
+// rewritten encrypted if rewritten is true.
+func (m *storageMetrics) observeCleartext(rewritten bool) {
+	if m == nil {
+		return
+	}
+	action := "loaded"
+	if rewritten {
+		action = "rewritten"
+	}
+	m.cleartext.WithLabelValues(action).Inc()
+}
+
+// errorClass returns a label value describing the cause of err.
+func errorClass(err error) string {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return "not_found"
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return "canceled"
+	}
+
+	var re *awshttp.ResponseError
+	if errors.As(err, &re) {
+		switch code := re.HTTPStatusCode(); {
+		case code == http.StatusNotFound:
+			return "not_found"
+		case code == http.StatusPreconditionFailed:
+			return "precondition_failed"
+		case code == http.StatusTooManyRequests || code == http.StatusServiceUnavailable:
+			return "throttled"
+		case code >= 500:
+			return "server"
+		default:
+			return "client"
+		}
+	}
+	if errors.As(err, new(*smithy.OperationError)) {
+		return "network"
+	}
+	return "other"
+}
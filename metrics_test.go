@@ -0,0 +1,120 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestS3_metrics(t *testing.T) {
+	ctx := context.Background()
+	cert := []byte("-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n")
+
+	s3, fake := newTestS3(t)
+
+	var err error
+	registry := prometheus.NewRegistry()
+	s3.metrics, err = newStorageMetrics(registry, t.Name())
+	assertNoError(t, err, "newStorageMetrics()")
+	// a second storage shares the collectors
+	_, err = newStorageMetrics(registry, t.Name()+"-2")
+	assertNoError(t, err, "newStorageMetrics()")
+
+	assertNoError(t, s3.Store(ctx, "site.crt", cert), "Store()")
+	_, err = s3.Load(ctx, "site.crt")
+	assertNoError(t, err, "Load()")
+	_, err = s3.Load(ctx, "missing.crt")
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Load() error = %v, want fs.ErrNotExist", err)
+	}
+	assertNoError(t, s3.Lock(ctx, "site.crt"), "Lock()")
+	assertNoError(t, s3.Unlock(ctx, "site.crt"), "Unlock()")
+	// failed operations transfer no bytes
+	fake.setFailing(true)
+	assertError(t, s3.Store(ctx, "site.crt", cert), "failed to store key", "Store()")
+
+	c := &storageCollectors
+	tests := []struct {
+		name      string
+		collector prometheus.Collector
+		want      float64
+	}{
+		{name: "stores", collector: c.operations.WithLabelValues(t.Name(), opStore), want: 2},
+		{name: "failed stores", collector: c.errors.WithLabelValues(t.Name(), opStore, "throttled"), want: 1},
+		{name: "loads", collector: c.operations.WithLabelValues(t.Name(), opLoad), want: 2},
+		{name: "not found", collector: c.errors.WithLabelValues(t.Name(), opLoad, "not_found"), want: 1},
+		{name: "bytes stored", collector: c.bytes.WithLabelValues(t.Name(), opStore), want: float64(len(cert))},
+		{name: "bytes loaded", collector: c.bytes.WithLabelValues(t.Name(), opLoad), want: float64(len(cert))},
+		{name: "locks", collector: c.operations.WithLabelValues(t.Name(), opLock), want: 1},
+		{name: "unlocks", collector: c.operations.WithLabelValues(t.Name(), opUnlock), want: 1},
+		{name: "contention", collector: c.lockContend.WithLabelValues(t.Name()), want: 0},
+	}
+	for _, tt := range tests {
+		if got := testutil.ToFloat64(tt.collector); got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	families, err := registry.Gather()
+	assertNoError(t, err, "Gather()")
+	var lockWaits uint64
+	for _, family := range families {
+		if family.GetName() != "caddy_storage_s3_lock_wait_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			if metric.GetLabel()[0].GetValue() == t.Name() {
+				lockWaits = metric.GetHistogram().GetSampleCount()
+			}
+		}
+	}
+	if lockWaits != 1 {
+		t.Errorf("lock waits = %d, want 1", lockWaits)
+	}
+}
+
+func TestS3_metricsLockContention(t *testing.T) {
+	ctx := context.Background()
+	s3, _ := newTestS3(t)
+
+	var err error
+	s3.metrics, err = newStorageMetrics(prometheus.NewRegistry(), t.Name())
+	assertNoError(t, err, "newStorageMetrics()")
+
+	pollInterval := LockPollInterval
+	LockPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { LockPollInterval = pollInterval })
+
+	assertNoError(t, s3.Lock(ctx, "site.crt"), "Lock()")
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		_ = s3.Unlock(ctx, "site.crt")
+	}()
+	assertNoError(t, s3.Lock(ctx, "site.crt"), "Lock()")
+
+	if got := testutil.ToFloat64(storageCollectors.lockContend.WithLabelValues(t.Name())); got != 1 {
+		t.Errorf("lock contention = %v, want 1", got)
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{err: fs.ErrNotExist, want: "not_found"},
+		{err: fmt.Errorf("failed: %w", context.DeadlineExceeded), want: "canceled"},
+		{err: errors.New("failed to read/decrypt data"), want: "other"},
+	}
+	for _, tt := range tests {
+		if got := errorClass(tt.err); got != tt.want {
+			t.Errorf("errorClass(%v) = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+}
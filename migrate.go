@@ -0,0 +1,223 @@
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	s3sdk "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/caddyserver/caddy/v2"
+	caddycmd "github.com/caddyserver/caddy/v2/cmd"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddycmd.RegisterCommand(caddycmd.Command{
+		Name:  "s3-migrate",
+		Usage: "--from <config> --to <config> [--adapter <name>] [--dry-run] [--state <file>]",
+		Short: "Re-encrypts objects in S3 storage with a new configuration",
+		Long: `
+Walks all objects below the prefix of the S3 storage configured in the --from
+config, decrypts them with its encryption settings and stores them again using
+the S3 storage configured in the --to config. This is used to rewrite existing
+certificates after rotating the encryption key or changing the encryption
+settings. Both configs are regular Caddy configs, adapted with --adapter if
+given, with an s3 storage module in their "storage" setting.
+
+--dry-run only loads and decrypts every object without storing anything. With
+cleartext_reads enabled in the --from config, this reports how many objects are
+still unencrypted.
+
+--state names a file the last migrated object is recorded in. If the migration
+is interrupted or fails, running the command again with the same state file
+resumes after the last migrated object. Dry runs do not update the state file.
+`,
+		CobraFunc: func(cmd *cobra.Command) {
+			cmd.Flags().String("from", "", "Config with the current storage settings")
+			cmd.Flags().String("to", "", "Config with the new storage settings")
+			cmd.Flags().StringP("adapter", "a", "", "Name of config adapter to apply")
+			cmd.Flags().Bool("dry-run", false, "Only decrypt objects, do not store them")
+			cmd.Flags().String("state", "", "File to record progress in, for resuming")
+			cmd.RunE = caddycmd.WrapCommandFuncForCobra(cmdMigrate)
+		},
+	})
+}
+
+func cmdMigrate(fl caddycmd.Flags) (int, error) {
+	fromConfig, toConfig := fl.String("from"), fl.String("to")
+	if fromConfig == "" || toConfig == "" {
+		return caddy.ExitCodeFailedStartup, errors.New("--from and --to are required")
+	}
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	from, err := loadStorageConfig(ctx, fromConfig, fl.String("adapter"))
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, fmt.Errorf("loading --from storage: %w", err)
+	}
+	to, err := loadStorageConfig(ctx, toConfig, fl.String("adapter"))
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, fmt.Errorf("loading --to storage: %w", err)
+	}
+
+	opts := MigrateOptions{DryRun: fl.Bool("dry-run")}
+	if stateFile := fl.String("state"); stateFile != "" {
+		state, err := os.ReadFile(stateFile) // #nosec G304 -- path is provided by the operator
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return caddy.ExitCodeFailedStartup, fmt.Errorf("reading state file: %w", err)
+		}
+		opts.StartAfter = strings.TrimSpace(string(state))
+		if !opts.DryRun {
+			opts.Migrated = func(key string) error {
+				return os.WriteFile(stateFile, []byte(key+"\n"), 0o600)
+			}
+		}
+	}
+
+	summary, err := Migrate(ctx, from, to, opts)
+	fmt.Printf("migrated: %d, skipped: %d, unencrypted: %d, bytes: %d, dry run: %v\n",
+		summary.Migrated, summary.Skipped, summary.Cleartext, summary.Bytes, opts.DryRun)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	return caddy.ExitCodeSuccess, nil
+}
+
+// loadStorageConfig loads the Caddy config from configFile and provisions its s3 storage module.
+func loadStorageConfig(ctx caddy.Context, configFile, adapter string) (*S3, error) {
+	buf, _, err := caddycmd.LoadConfig(configFile, adapter)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg struct {
+		StorageRaw json.RawMessage `json:"storage,omitempty" caddy:"namespace=caddy.storage inline_key=module"`
+	}
+	if err := json.Unmarshal(buf, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.StorageRaw == nil {
+		return nil, errors.New("config does not have a storage module")
+	}
+
+	val, err := ctx.LoadModule(&cfg, "StorageRaw")
+	if err != nil {
+		return nil, err
+	}
+	s3, ok := val.(*S3)
+	if !ok {
+		return nil, fmt.Errorf("storage module is %T, not s3", val)
+	}
+	return s3, nil
+}
+
+// MigrateOptions configures Migrate.
+type MigrateOptions struct {
+	// DryRun only loads and decrypts the objects.
+	DryRun bool
+
+	// StartAfter is the object key after which the migration starts, to resume
+	// an earlier migration.
+	StartAfter string
+
+	// Migrated is optionally called with the object key of every migrated object.
+	Migrated func(objName string) error
+}
+
+// MigrateSummary reports the progress of Migrate.
+type MigrateSummary struct {
+	Migrated int
+	Skipped  int
+	Bytes    int64
+
+	// Cleartext is the number of unencrypted objects read with CleartextReads.
+	Cleartext int
+
+	// LastKey is the object key of the last migrated object.
+	LastKey string
+}
+
+// Migrate loads all objects below the prefix of from and stores them using to.
+// Lock files and the key derivation parameters are skipped. Objects are
+// processed in lexicographical order, and Migrate stops at the first error,
+// so that the migration can be resumed after the LastKey of the summary.
+func Migrate(ctx context.Context, from, to *S3, opts MigrateOptions) (MigrateSummary, error) {
+	var summary MigrateSummary
+
+	prefix := from.objName("")
+	input := &s3sdk.ListObjectsV2Input{
+		Bucket: aws.String(from.Bucket),
+		Prefix: aws.String(prefix),
+	}
+	if opts.StartAfter != "" {
+		input.StartAfter = aws.String(opts.StartAfter)
+	}
+
+	paginator := s3sdk.NewListObjectsV2Paginator(from.Client, input)
+	for paginator.HasMorePages() {
+		result, err := paginator.NextPage(ctx)
+		if err != nil {
+			return summary, err
+		}
+
+		for _, obj := range result.Contents {
+			objName := aws.ToString(obj.Key)
+			key := strings.TrimPrefix(objName, prefix)
+
+			if strings.HasSuffix(key, ".lock") || key == KDFParamsKey {
+				summary.Skipped++
+				continue
+			}
+
+			obj, err := from.load(ctx, key, nil)
+			if err != nil {
+				return summary, fmt.Errorf("migrating %s: %w", objName, err)
+			}
+			value := obj.data
+			if obj.cleartext {
+				summary.Cleartext++
+			}
+			if obj.unverified {
+				// it may be ciphertext under a key that is no longer configured
+				from.Logger.Warn("not migrating object that failed to decrypt and is not known to be unencrypted",
+					zap.String("key", objName),
+				)
+				summary.Skipped++
+				continue
+			}
+			if len(value) == 0 {
+				summary.Skipped++
+				continue
+			}
+
+			if !opts.DryRun {
+				if err := to.Store(ctx, key, value); err != nil {
+					return summary, fmt.Errorf("migrating %s: %w", objName, err)
+				}
+			}
+
+			summary.Migrated++
+			summary.Bytes += int64(len(value))
+			summary.LastKey = objName
+			from.Logger.Info("migrated object",
+				zap.String("key", objName),
+				zap.Int("migrated", summary.Migrated),
+				zap.Bool("dry_run", opts.DryRun),
+			)
+
+			if opts.Migrated != nil {
+				if err := opts.Migrated(objName); err != nil {
+					return summary, err
+				}
+			}
+		}
+	}
+
+	return summary, nil
+}
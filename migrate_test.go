@@ -0,0 +1,80 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	from, fake := newTestS3(t)
+	to := *from
+	to.iowrap = NewSecretBoxIO(testKey32)
+
+	objects := map[string]string{
+		"certificates/a/a.crt": "certificate a",
+		"certificates/b/b.crt": "certificate b",
+		"certificates/c/c.crt": "certificate c",
+	}
+	for key, value := range objects {
+		assertNoError(t, from.Store(ctx, key, []byte(value)), "Store()")
+	}
+	fake.put(from.objLockName("certificates/a/a.crt"), []byte("lock"))
+
+	before := make(map[string][]byte)
+	for key := range objects {
+		before[key], _ = fake.get(from.objName(key))
+	}
+
+	t.Run("dry run", func(t *testing.T) {
+		summary, err := Migrate(ctx, from, &to, MigrateOptions{DryRun: true})
+		assertNoError(t, err, "Migrate()")
+		if summary.Migrated != 3 || summary.Skipped != 1 {
+			t.Errorf("Migrate() = %+v, want 3 migrated and 1 skipped", summary)
+		}
+		for key := range objects {
+			if stored, _ := fake.get(from.objName(key)); !bytes.Equal(stored, before[key]) {
+				t.Errorf("dry run modified %s", key)
+			}
+		}
+	})
+
+	t.Run("resume", func(t *testing.T) {
+		var migrated []string
+		summary, err := Migrate(ctx, from, &to, MigrateOptions{
+			StartAfter: from.objName("certificates/a/a.crt.lock"),
+			Migrated: func(objName string) error {
+				migrated = append(migrated, objName)
+				return nil
+			},
+		})
+		assertNoError(t, err, "Migrate()")
+		if summary.Migrated != 2 || summary.LastKey != from.objName("certificates/c/c.crt") {
+			t.Errorf("Migrate() = %+v, want 2 migrated ending with c.crt", summary)
+		}
+		if len(migrated) != 2 {
+			t.Errorf("Migrated called for %v, want 2 objects", migrated)
+		}
+
+		stored, _ := fake.get(from.objName("certificates/b/b.crt"))
+		buf, err := io.ReadAll(to.iowrap.WrapReader(from.objName("certificates/b/b.crt"), bytes.NewReader(stored)))
+		assertNoError(t, err, "decrypting migrated object")
+		if string(buf) != "certificate b" {
+			t.Errorf("migrated object = %q, want %q", buf, "certificate b")
+		}
+		if stored, _ := fake.get(from.objName("certificates/a/a.crt")); !bytes.Equal(stored, before["certificates/a/a.crt"]) {
+			t.Error("object before StartAfter was migrated")
+		}
+	})
+
+	t.Run("stops at undecryptable object", func(t *testing.T) {
+		// b.crt is encrypted now and cannot be loaded as cleartext by the secretbox reader
+		summary, err := Migrate(ctx, &to, from, MigrateOptions{})
+		assertError(t, err, "migrating acme/certificates/a/a.crt", "Migrate()")
+		if summary.Migrated != 0 {
+			t.Errorf("Migrate() = %+v, want nothing migrated", summary)
+		}
+	})
+}
@@ -13,17 +13,23 @@ import (
 	"strings"
 	"time"
 
+	"filippo.io/age"
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/processcreds"
 	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
 	s3sdk "github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"github.com/caddyserver/certmagic"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
 )
 
 var ErrInvalidKey = errors.New("invalid key")
@@ -40,15 +46,148 @@ type S3 struct {
 	Region       string `json:"region"`
 	AccessKey    string `json:"access_key"`
 	SecretKey    string `json:"secret_key"`
+	SessionToken string `json:"session_token,omitempty"`
 	Profile      string `json:"profile"`
 	RoleARN      string `json:"role_arn"`
 	Prefix       string `json:"prefix"`
 	UsePathStyle bool   `json:"use_path_style,omitempty"`
 
+	// CredentialProcess is an optional command that is executed to obtain credentials,
+	// using the same JSON output format as the AWS CLI's credential_process setting.
+	CredentialProcess string `json:"credential_process,omitempty"`
+
 	// EncryptionKey is optional. If you do not wish to encrypt your certficates and key inside the S3 bucket, leave it empty.
 	EncryptionKey string `json:"encryption_key"`
 
-	iowrap IO
+	// EncryptionAlgorithm is the cipher new objects are encrypted with: secretbox (default),
+	// xchacha20-poly1305 or aes-256-gcm. The latter two also authenticate the name of the object.
+	EncryptionAlgorithm string `json:"encryption_algorithm,omitempty"`
+
+	// EncryptionLegacySecretBox allows xchacha20-poly1305 and aes-256-gcm to read objects that
+	// were encrypted with secretbox and are therefore not bound to their name. Enable it only
+	// while migrating a bucket, as these objects could be swapped within it.
+	EncryptionLegacySecretBox bool `json:"encryption_legacy_secretbox,omitempty"`
+
+	// EncryptionKeyEncoding is the encoding of EncryptionKey: raw, hex, base64 or auto (default).
+	EncryptionKeyEncoding string `json:"encryption_key_encoding,omitempty"`
+
+	// EncryptionKeyring maps key IDs to encryption keys (encoded as EncryptionKeyEncoding).
+	// New objects are encrypted with EncryptionPrimaryKey, all keys are used for decryption.
+	EncryptionKeyring    map[string]string `json:"encryption_keyring,omitempty"`
+	EncryptionPrimaryKey string            `json:"encryption_primary_key,omitempty"`
+
+	// EncryptionPassphrase can be used instead of EncryptionKey. The key is derived with EncryptionKDF
+	// (argon2id or scrypt) using a salt that is stored in the bucket below Prefix.
+	EncryptionPassphrase string `json:"encryption_passphrase,omitempty"`
+	EncryptionKDF        string `json:"encryption_kdf,omitempty"`
+
+	// IntegrityKey enables storing objects unencrypted, but authenticated with an HMAC-SHA256
+	// tag, so that modifications outside of Caddy are detected. It is encoded like EncryptionKey
+	// and cannot be combined with encryption.
+	IntegrityKey     string `json:"integrity_key,omitempty"`
+	IntegrityKeyFile string `json:"integrity_key_file,omitempty"`
+
+	// SecretKeyFile and EncryptionKeyFile allow reading the respective secrets from a file instead.
+	SecretKeyFile     string `json:"secret_key_file,omitempty"`
+	EncryptionKeyFile string `json:"encryption_key_file,omitempty"`
+
+	// EncryptionKMSKeyID enables envelope encryption with per-object data keys that are
+	// wrapped by this AWS KMS key (ID, ARN or alias). KMSEndpoint optionally overrides
+	// the KMS endpoint.
+	EncryptionKMSKeyID string `json:"encryption_kms_key_id,omitempty"`
+	KMSEndpoint        string `json:"kms_endpoint,omitempty"`
+
+	// VaultTransitKey enables envelope encryption with per-object data keys that are wrapped
+	// by this key of the transit secrets engine of the Vault server at VaultAddress.
+	// Vault is authenticated with VaultToken (or VaultTokenFile), or AppRole if VaultRoleID is set.
+	VaultAddress      string `json:"vault_address,omitempty"`
+	VaultNamespace    string `json:"vault_namespace,omitempty"`
+	VaultTransitMount string `json:"vault_transit_mount,omitempty"`
+	VaultTransitKey   string `json:"vault_transit_key,omitempty"`
+	VaultToken        string `json:"vault_token,omitempty"`
+	VaultTokenFile    string `json:"vault_token_file,omitempty"`
+	VaultAppRoleMount string `json:"vault_approle_mount,omitempty"`
+	VaultRoleID       string `json:"vault_role_id,omitempty"`
+	VaultSecretID     string `json:"vault_secret_id,omitempty"`
+
+	// EncryptionRecipients are age X25519 public keys (age1...) new objects are encrypted to.
+	// Objects are decrypted with the age identities in EncryptionIdentityFile. A node with
+	// recipients but without identities can store certificates, but not read them. As the
+	// recipients are public keys, this keeps objects confidential, but does not authenticate them.
+	EncryptionRecipients   []string `json:"encryption_recipients,omitempty"`
+	EncryptionIdentityFile string   `json:"encryption_identity_file,omitempty"`
+
+	// SSE enables server-side encryption by S3: AES256, aws:kms or SSE-C. With aws:kms,
+	// SSEKMSKeyID optionally selects the KMS key and SSEBucketKey enables S3 Bucket Keys.
+	// SSE-C encrypts with SSECustomerKey, a 32 byte key that is sent with every request.
+	SSE            string `json:"sse,omitempty"`
+	SSEKMSKeyID    string `json:"sse_kms_key_id,omitempty"`
+	SSEBucketKey   bool   `json:"sse_bucket_key,omitempty"`
+	SSECustomerKey string `json:"sse_customer_key,omitempty"`
+
+	// Compression optionally compresses values with gzip or zstd before they are encrypted.
+	// Values smaller than CompressionMinSize bytes (default 512, at least 1) are stored uncompressed.
+	// Compressed objects can always be read, even when compression is disabled again.
+	Compression        string `json:"compression,omitempty"`
+	CompressionMinSize int    `json:"compression_min_size,omitempty"`
+
+	// CleartextReads allows loading unencrypted objects while encryption is rolled out on an
+	// existing bucket: "detect" accepts objects stored without encryption and objects without
+	// envelope that are text, as written by certmagic; "fallback" accepts every object without
+	// envelope that fails to decrypt. CleartextRewrite stores such objects again, encrypted
+	// with the current settings, when they are loaded.
+	CleartextReads   string `json:"cleartext_reads,omitempty"`
+	CleartextRewrite bool   `json:"cleartext_rewrite,omitempty"`
+
+	// DataKeyCacheTTL is how long data keys unwrapped by KMS or Vault are cached (negative to disable).
+	DataKeyCacheTTL caddy.Duration `json:"data_key_cache_ttl,omitempty"`
+
+	// ReadCacheSize enables an in-memory LRU cache of up to this many loaded values. Cached
+	// values older than ReadCacheTTL (default 1m) are revalidated with their ETag, a negative
+	// TTL revalidates every load. Local stores and deletes invalidate the cache.
+	ReadCacheSize int            `json:"read_cache_size,omitempty"`
+	ReadCacheTTL  caddy.Duration `json:"read_cache_ttl,omitempty"`
+
+	// RequestLimits bounds the rate and concurrency of the requests sent to S3, with separate
+	// budgets for reads, writes and lock polling ("read", "write" and "lock").
+	RequestLimits map[string]*RequestLimit `json:"request_limits,omitempty"`
+
+	// Audit enables recording an event for every change made by Store, Delete, Lock and Unlock,
+	// either to the "audit" logger ("log") or as objects below AuditPrefix in the bucket ("bucket").
+	// AuditInstance identifies this instance in the events, and defaults to the hostname.
+	Audit         string `json:"audit,omitempty"`
+	AuditPrefix   string `json:"audit_prefix,omitempty"`
+	AuditInstance string `json:"audit_instance,omitempty"`
+
+	// FallbackDir enables keeping a copy of stored and loaded values in this local directory,
+	// wrapped like the objects in S3, which are loaded while S3 is unavailable if they were last
+	// known to match S3 less than FallbackMaxAge ago (default 7 days, negative for no limit).
+	FallbackDir    string         `json:"fallback_dir,omitempty"`
+	FallbackMaxAge caddy.Duration `json:"fallback_max_age,omitempty"`
+
+	iowrap    IO
+	awsConfig aws.Config
+
+	sseCustomerKey    string
+	sseCustomerKeyMD5 string
+
+	cleartext IO
+
+	cache    *readCache
+	fallback *diskCache
+
+	limiters map[string]*requestLimiter
+
+	// flight coalesces concurrent loads and HEAD requests of the same object
+	flight *singleflight.Group
+
+	metrics        *storageMetrics
+	tracerProvider trace.TracerProvider
+
+	auditSink auditSink
+
+	// redacted are the names of the secrets cleared by clearSecrets
+	redacted []string
 }
 
 func init() {
@@ -65,16 +204,80 @@ func (s3 *S3) Provision(ctx caddy.Context) error {
 		)
 	}
 
-	client, err := s3.buildS3Client()
+	if err := s3.loadSecrets(); err != nil {
+		return err
+	}
+	if err := s3.setupSSE(); err != nil {
+		return err
+	}
+	if err := s3.setupRequestLimits(); err != nil {
+		return err
+	}
+
+	cfg, err := s3.loadAWSConfig()
 	if err != nil {
 		return fmt.Errorf("failed to create S3 client: %w", err)
 	}
 
-	s3.Client = client
-	return s3.setupEncryption()
+	s3.Client = s3.buildS3Client(cfg)
+	s3.awsConfig = cfg
+	s3.flight = new(singleflight.Group)
+	if registry := ctx.GetMetricsRegistry(); registry != nil {
+		if s3.metrics, err = newStorageMetrics(registry, s3.Bucket); err != nil {
+			return fmt.Errorf("failed to register metrics: %w", err)
+		}
+	}
+	if err := s3.setupEncryption(ctx); err != nil {
+		return err
+	}
+	if err := s3.setupCompression(); err != nil {
+		return err
+	}
+	if err := s3.setupCleartextReads(); err != nil {
+		return err
+	}
+	if err := s3.setupReadCache(); err != nil {
+		return err
+	}
+	if err := s3.setupFallback(); err != nil {
+		return err
+	}
+	if err := s3.setupAudit(); err != nil {
+		return err
+	}
+
+	s3.clearSecrets()
+	return nil
 }
 
-func (s3 *S3) buildS3Client() (*s3sdk.Client, error) {
+// setupCompression wraps iowrap in a CompressIO, which is also needed to read
+// compressed objects when compression is disabled.
+func (s3 *S3) setupCompression() error {
+	algorithm := AlgorithmNone
+	if s3.Compression != "" {
+		var err error
+		if algorithm, err = ParseCompression(s3.Compression); err != nil {
+			return err
+		}
+	}
+
+	minSize := s3.CompressionMinSize
+	if minSize < 0 {
+		return errors.New("'compression_min_size' must be at least 1")
+	}
+	if minSize == 0 {
+		minSize = DefaultCompressionMinSize
+	}
+
+	c, err := NewCompressIO(s3.iowrap, algorithm, minSize)
+	if err != nil {
+		return err
+	}
+	s3.iowrap = c
+	return nil
+}
+
+func (s3 *S3) loadAWSConfig() (aws.Config, error) {
 	configOptions := []func(*config.LoadOptions) error{
 		config.WithRegion(s3.Region),
 	}
@@ -97,16 +300,15 @@ func (s3 *S3) buildS3Client() (*s3sdk.Client, error) {
 		configOptions = append(configOptions, config.WithHTTPClient(httpClient))
 	}
 
-	if s3.AccessKey != "" && s3.SecretKey != "" {
-		configOptions = append(configOptions, config.WithCredentialsProvider(
-			credentials.NewStaticCredentialsProvider(s3.AccessKey, s3.SecretKey, "")))
+	if provider := s3.credentialsProvider(); provider != nil {
+		configOptions = append(configOptions, config.WithCredentialsProvider(provider))
 	} else if s3.Profile != "" {
 		configOptions = append(configOptions, config.WithSharedConfigProfile(s3.Profile))
 	}
 
 	cfg, err := config.LoadDefaultConfig(context.Background(), configOptions...)
 	if err != nil {
-		return nil, err
+		return cfg, err
 	}
 
 	if s3.RoleARN != "" {
@@ -115,6 +317,10 @@ func (s3 *S3) buildS3Client() (*s3sdk.Client, error) {
 		cfg.Credentials = aws.NewCredentialsCache(provider)
 	}
 
+	return cfg, nil
+}
+
+func (s3 *S3) buildS3Client(cfg aws.Config) *s3sdk.Client {
 	var s3Options []func(*s3sdk.Options)
 
 	if s3.Endpoint != "" {
@@ -129,26 +335,236 @@ func (s3 *S3) buildS3Client() (*s3sdk.Client, error) {
 		})
 	}
 
-	return s3sdk.NewFromConfig(cfg, s3Options...), nil
+	if len(s3.limiters) > 0 {
+		s3Options = append(s3Options, s3.withRequestLimits)
+	}
+	s3Options = append(s3Options, s3.withTracing)
+
+	return s3sdk.NewFromConfig(cfg, s3Options...)
+}
+
+func (s3 *S3) buildKMSClient() *kms.Client {
+	return kms.NewFromConfig(s3.awsConfig, func(o *kms.Options) {
+		if s3.KMSEndpoint != "" {
+			o.BaseEndpoint = aws.String(s3.KMSEndpoint)
+		}
+	})
 }
 
-func (s3 *S3) setupEncryption() error {
-	if len(s3.EncryptionKey) == 0 {
+// credentialsProvider returns the explicitly configured credentials provider,
+// or nil if the SDK's default credential chain should be used.
+func (s3 *S3) credentialsProvider() aws.CredentialsProvider {
+	if s3.AccessKey != "" && s3.SecretKey != "" {
+		return credentials.NewStaticCredentialsProvider(s3.AccessKey, s3.SecretKey, s3.SessionToken)
+	}
+	if s3.CredentialProcess != "" {
+		// the cache refreshes the credentials shortly before the expiration reported by the process
+		return aws.NewCredentialsCache(processcreds.NewProvider(s3.CredentialProcess))
+	}
+	return nil
+}
+
+func (s3 *S3) setupEncryption(ctx context.Context) error {
+	if s3.EncryptionKey != "" && s3.EncryptionPassphrase != "" {
+		return errors.New("cannot specify both 'encryption_key' and 'encryption_passphrase' options")
+	}
+	if len(s3.EncryptionKeyring) > 0 && (s3.EncryptionKey != "" || s3.EncryptionPassphrase != "") {
+		return errors.New("cannot combine 'encryption_keyring' with 'encryption_key' or 'encryption_passphrase'")
+	}
+	staticKeys := s3.EncryptionKey != "" || s3.EncryptionPassphrase != "" || len(s3.EncryptionKeyring) > 0
+	ageKeys := len(s3.EncryptionRecipients) > 0 || s3.EncryptionIdentityFile != ""
+	if s3.EncryptionKMSKeyID != "" && (staticKeys || s3.VaultTransitKey != "" || ageKeys) {
+		return errors.New("cannot combine 'encryption_kms_key_id' with other encryption keys")
+	}
+	if s3.VaultTransitKey != "" && (staticKeys || ageKeys) {
+		return errors.New("cannot combine 'vault_transit_key' with other encryption keys")
+	}
+	if ageKeys && staticKeys {
+		return errors.New("cannot combine age recipients or identities with static encryption keys")
+	}
+	if s3.IntegrityKey != "" && (staticKeys || ageKeys || s3.EncryptionKMSKeyID != "" || s3.VaultTransitKey != "") {
+		return errors.New("cannot combine 'integrity_key' with encryption, which already detects modifications")
+	}
+
+	if s3.IntegrityKey != "" {
+		key, err := decodeEncryptionKey(s3.IntegrityKey, s3.EncryptionKeyEncoding)
+		if err != nil {
+			s3.Logger.Error("invalid integrity key", zap.Error(err))
+			return fmt.Errorf("invalid integrity_key: %w", err)
+		}
+		s3.Logger.Info("Clear text certificate storage active, authenticated with HMAC-SHA256")
+		s3.iowrap = NewHMACIO(key)
+		return nil
+	}
+
+	if ageKeys {
+		ageIO, err := s3.ageIO()
+		if err != nil {
+			s3.Logger.Error("invalid age configuration", zap.Error(err))
+			return err
+		}
+		s3.Logger.Info("Encrypted certificate storage active, using age",
+			zap.Int("recipients", len(ageIO.recipients)),
+			zap.Bool("can_decrypt", len(ageIO.identities) > 0),
+		)
+		s3.iowrap = ageIO
+		return nil
+	}
+
+	if s3.VaultTransitKey != "" {
+		client, err := s3.vaultClient()
+		if err != nil {
+			return err
+		}
+		s3.Logger.Info("Encrypted certificate storage active, using Vault transit",
+			zap.String("vault_address", client.Address),
+			zap.String("transit_key", client.TransitKey),
+			zap.Bool("approle", client.RoleID != ""),
+		)
+		s3.iowrap = NewVaultIO(client, s3.dataKeyCacheTTL())
+		return nil
+	}
+
+	if s3.EncryptionKMSKeyID != "" {
+		s3.Logger.Info("Encrypted certificate storage active, using AWS KMS",
+			zap.String("kms_key_id", s3.EncryptionKMSKeyID),
+		)
+		s3.iowrap = NewKMSIO(s3.buildKMSClient(), s3.EncryptionKMSKeyID, s3.dataKeyCacheTTL())
+		return nil
+	}
+
+	algorithm := AlgorithmSecretBox
+	if s3.EncryptionAlgorithm != "" {
+		var err error
+		if algorithm, err = ParseAlgorithm(s3.EncryptionAlgorithm); err != nil {
+			return err
+		}
+	}
+
+	if len(s3.EncryptionKeyring) > 0 {
+		kr, err := s3.keyringIO(algorithm)
+		if err != nil {
+			s3.Logger.Error("invalid encryption keyring", zap.Error(err))
+			return err
+		}
+		s3.Logger.Info("Encrypted certificate storage active, using keyring",
+			zap.String("primary_key", kr.primary),
+			zap.Int("keys", len(kr.keys)),
+			zap.Stringer("algorithm", algorithm),
+		)
+		s3.allowLegacySecretBox(kr)
+		s3.iowrap = kr
+	} else if s3.EncryptionPassphrase != "" {
+		key, err := s3.passphraseKey(ctx)
+		if err != nil {
+			s3.Logger.Error("failed to derive encryption key from passphrase", zap.Error(err))
+			return err
+		}
+		s3.Logger.Info("Encrypted certificate storage active, using passphrase-derived key",
+			zap.Stringer("algorithm", algorithm),
+		)
+		return s3.setupCipher(algorithm, key)
+	} else if len(s3.EncryptionKey) == 0 {
 		s3.Logger.Info("Clear text certificate storage active")
 		s3.iowrap = &CleartextIO{}
-	} else if len(s3.EncryptionKey) != 32 {
-		s3.Logger.Error("encryption key must have exactly 32 bytes")
-		return errors.New("encryption key must have exactly 32 bytes")
 	} else {
-		s3.Logger.Info("Encrypted certificate storage active")
-		sb := &SecretBoxIO{}
-		copy(sb.SecretKey[:], []byte(s3.EncryptionKey))
-		s3.iowrap = sb
+		key, err := decodeEncryptionKey(s3.EncryptionKey, s3.EncryptionKeyEncoding)
+		if err != nil {
+			s3.Logger.Error("invalid encryption key", zap.Error(err))
+			return err
+		}
+		s3.Logger.Info("Encrypted certificate storage active",
+			zap.Stringer("algorithm", algorithm),
+		)
+		return s3.setupCipher(algorithm, key)
 	}
 
 	return nil
 }
 
+func (s3 *S3) setupCipher(algorithm Algorithm, key [32]byte) error {
+	c, err := newKeyedCipher(algorithm, key)
+	if err != nil {
+		return err
+	}
+	if l, ok := c.(legacyReader); ok {
+		s3.allowLegacySecretBox(l)
+	}
+	s3.iowrap = c
+	return nil
+}
+
+func (s3 *S3) allowLegacySecretBox(l legacyReader) {
+	if s3.EncryptionLegacySecretBox {
+		s3.Logger.Warn("reading objects encrypted with secretbox, which are not bound to their name; disable 'encryption_legacy_secretbox' once they are migrated")
+		l.AllowLegacySecretBox()
+	}
+}
+
+func (s3 *S3) ageIO() (*AgeIO, error) {
+	recipients, err := ParseAgeRecipients(s3.EncryptionRecipients)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption_recipients: %w", err)
+	}
+
+	var identities []age.Identity
+	if s3.EncryptionIdentityFile != "" {
+		if identities, err = LoadAgeIdentities(s3.EncryptionIdentityFile); err != nil {
+			return nil, fmt.Errorf("failed to load encryption_identity_file: %w", err)
+		}
+	}
+	return NewAgeIO(recipients, identities), nil
+}
+
+func (s3 *S3) vaultClient() (*VaultClient, error) {
+	if s3.VaultAddress == "" {
+		return nil, errors.New("'vault_address' is required for 'vault_transit_key'")
+	}
+	if s3.VaultToken == "" && s3.VaultRoleID == "" {
+		return nil, errors.New("either 'vault_token' or 'vault_role_id' is required for 'vault_transit_key'")
+	}
+	return &VaultClient{
+		HTTPClient:   &http.Client{Timeout: DataKeyTimeout},
+		Address:      s3.VaultAddress,
+		Namespace:    s3.VaultNamespace,
+		TransitMount: s3.VaultTransitMount,
+		TransitKey:   s3.VaultTransitKey,
+		Token:        s3.VaultToken,
+		AppRoleMount: s3.VaultAppRoleMount,
+		RoleID:       s3.VaultRoleID,
+		SecretID:     s3.VaultSecretID,
+	}, nil
+}
+
+func (s3 *S3) dataKeyCacheTTL() time.Duration {
+	if s3.DataKeyCacheTTL == 0 {
+		return DataKeyCacheTTL
+	}
+	return time.Duration(s3.DataKeyCacheTTL)
+}
+
+func (s3 *S3) keyringIO(algorithm Algorithm) (*KeyringIO, error) {
+	keys := make(map[string][32]byte, len(s3.EncryptionKeyring))
+	for id, encoded := range s3.EncryptionKeyring {
+		key, err := decodeEncryptionKey(encoded, s3.EncryptionKeyEncoding)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %q: %w", id, err)
+		}
+		keys[id] = key
+	}
+
+	primary := s3.EncryptionPrimaryKey
+	if primary == "" && len(keys) == 1 {
+		for id := range keys {
+			primary = id
+		}
+	}
+	if primary == "" {
+		return nil, errors.New("'encryption_primary_key' is required when the keyring holds more than one key")
+	}
+	return NewKeyringIO(keys, primary, algorithm)
+}
+
 func (s3 *S3) CaddyModule() caddy.ModuleInfo {
 	return caddy.ModuleInfo{
 		ID: "caddy.storage.s3",
@@ -164,44 +580,62 @@ var (
 	LockTimeout      = 15 * time.Second
 )
 
-func (s3 *S3) Lock(ctx context.Context, key string) error {
+func (s3 *S3) Lock(ctx context.Context, key string) (err error) {
 	s3.Logger.Info(fmt.Sprintf("Lock: %v", s3.objName(key)))
 	startedAt := time.Now()
+	ctx, op := s3.startOperation(withLockBudget(ctx), opLock, s3.objLockName(key))
+
+	var contended bool
+	defer func() {
+		op.span.SetAttributes(attrContended.Bool(contended))
+		op.end(0, err)
+		if err == nil {
+			s3.metrics.observeLock(startedAt, contended)
+		}
+	}()
 
 	for {
+		// lock files are written without SSE-C, see putLockFile
 		input := &s3sdk.GetObjectInput{
 			Bucket: aws.String(s3.Bucket),
 			Key:    aws.String(s3.objLockName(key)),
 		}
 
 		result, err := s3.Client.GetObject(ctx, input)
-		if err != nil {
-			var nsk *types.NoSuchKey
-			if errors.As(err, &nsk) {
-				return s3.putLockFile(ctx, key)
+		if err == nil {
+			var buf []byte
+			buf, err = io.ReadAll(result.Body)
+			_ = result.Body.Close()
+			if err == nil {
+				lt, parseErr := time.Parse(time.RFC3339, string(buf))
+				if parseErr != nil {
+					// Lock file does not make sense, overwrite.
+					return s3.putLockFile(ctx, key)
+				}
+				if lt.Add(LockTimeout).Before(time.Now()) {
+					// Existing lock file expired, overwrite.
+					return s3.putLockFile(ctx, key)
+				}
 			}
-			continue
-		}
-
-		buf, err := io.ReadAll(result.Body)
-		_ = result.Body.Close()
-		if err != nil {
-			continue
-		}
-
-		lt, err := time.Parse(time.RFC3339, string(buf))
-		if err != nil {
-			// Lock file does not make sense, overwrite.
-			return s3.putLockFile(ctx, key)
-		}
-		if lt.Add(LockTimeout).Before(time.Now()) {
-			// Existing lock file expired, overwrite.
+		} else if errors.As(err, new(*types.NoSuchKey)) {
 			return s3.putLockFile(ctx, key)
 		}
 
+		// lock files that cannot be read are treated like held ones
+		if ctx.Err() != nil {
+			return err
+		}
 		if startedAt.Add(LockTimeout).Before(time.Now()) {
 			return errors.New("acquiring lock failed")
 		}
+		if err != nil {
+			s3.Logger.Warn("failed to read lock file",
+				zap.String("key", s3.objLockName(key)),
+				zap.Error(err),
+			)
+		} else {
+			contended = true
+		}
 		time.Sleep(LockPollInterval)
 	}
 }
@@ -217,26 +651,39 @@ func (s3 *S3) putLockFile(ctx context.Context, key string) error {
 		Body:          r,
 		ContentLength: aws.Int64(int64(len(lockData))),
 	}
+	s3.applySSELockPut(input)
 
-	_, err := s3.Client.PutObject(ctx, input)
+	out, err := s3.Client.PutObject(ctx, input)
+	if out == nil {
+		out = new(s3sdk.PutObjectOutput)
+	}
+	s3.audit(ctx, opLock, s3.objLockName(key), len(lockData), out.ETag, out.VersionId, err)
 	return err
 }
 
-func (s3 *S3) Unlock(ctx context.Context, key string) error {
+func (s3 *S3) Unlock(ctx context.Context, key string) (err error) {
 	s3.Logger.Info(fmt.Sprintf("Release lock: %v", s3.objName(key)))
+	ctx, op := s3.startOperation(withLockBudget(ctx), opUnlock, s3.objLockName(key))
+	defer func() { op.end(0, err) }()
 
 	input := &s3sdk.DeleteObjectInput{
 		Bucket: aws.String(s3.Bucket),
 		Key:    aws.String(s3.objLockName(key)),
 	}
 
-	_, err := s3.Client.DeleteObject(ctx, input)
+	out, err := s3.Client.DeleteObject(ctx, input)
+	if out == nil {
+		out = new(s3sdk.DeleteObjectOutput)
+	}
+	s3.audit(ctx, opUnlock, s3.objLockName(key), 0, nil, out.VersionId, err)
 	return err
 }
 
-func (s3 *S3) Store(ctx context.Context, key string, value []byte) error {
+func (s3 *S3) Store(ctx context.Context, key string, value []byte) (err error) {
 	start := time.Now()
 	objName := s3.objName(key)
+	ctx, op := s3.startOperation(ctx, opStore, objName)
+	defer func() { op.end(len(value), err) }()
 
 	if len(value) == 0 {
 		return fmt.Errorf("%w: cannot store empty value", ErrInvalidKey)
@@ -255,25 +702,50 @@ func (s3 *S3) Store(ctx context.Context, key string, value []byte) error {
 		)
 	}()
 
-	r := s3.iowrap.ByteReader(value)
+	if s3.cache != nil {
+		defer s3.cache.invalidate(objName)
+	}
+	defer s3.forget(objName)
+	out, err := s3.putObject(ctx, objName, value, nil)
+	s3.audit(ctx, opStore, objName, len(value), out.ETag, out.VersionId, err)
+	if err != nil {
+		return fmt.Errorf("failed to store key %s: %w", key, err)
+	}
+	return nil
+}
+
+// putObject stores value wrapped by iowrap. If ifMatch is set, the object is
+// only replaced if it still has that ETag. The output is never nil.
+func (s3 *S3) putObject(ctx context.Context, objName string, value []byte, ifMatch *string) (*s3sdk.PutObjectOutput, error) {
+	r := s3.iowrap.ByteReader(objName, value)
 
 	input := &s3sdk.PutObjectInput{
 		Bucket:        aws.String(s3.Bucket),
 		Key:           aws.String(objName),
 		Body:          &r,
 		ContentLength: aws.Int64(r.Len()),
+		IfMatch:       ifMatch,
 	}
+	s3.applySSEPut(input)
 
-	_, err := s3.Client.PutObject(ctx, input)
-	if err != nil {
-		return fmt.Errorf("failed to store key %s: %w", key, err)
+	out, err := s3.Client.PutObject(ctx, input)
+	if out == nil {
+		out = new(s3sdk.PutObjectOutput)
 	}
-	return nil
+	if err == nil && s3.fallback != nil {
+		// the wrapped object is written again as it was sent, instead of wrapping value twice
+		if _, err := r.Seek(0, io.SeekStart); err == nil {
+			s3.storeFallback(objName, &r)
+		}
+	}
+	return out, err
 }
 
-func (s3 *S3) Load(ctx context.Context, key string) ([]byte, error) {
+func (s3 *S3) Load(ctx context.Context, key string) (value []byte, err error) {
 	start := time.Now()
 	objName := s3.objName(key)
+	ctx, op := s3.startOperation(ctx, opLoad, objName)
+	defer func() { op.end(len(value), err) }()
 
 	s3.Logger.Info("loading object",
 		zap.String("key", objName),
@@ -287,31 +759,112 @@ func (s3 *S3) Load(ctx context.Context, key string) ([]byte, error) {
 		)
 	}()
 
+	data, err := s3.coalesce(ctx, flightLoad+objName, func(ctx context.Context) (any, error) {
+		return s3.loadShared(ctx, key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the value is shared by concurrent callers
+	return bytes.Clone(data.([]byte)), nil
+}
+
+// loadShared loads key for all concurrent Load calls.
+func (s3 *S3) loadShared(ctx context.Context, key string) ([]byte, error) {
+	var obj loadedObject
+	var err error
+	if s3.cache != nil {
+		obj, err = s3.loadCached(ctx, key)
+	} else {
+		obj, err = s3.load(ctx, key, nil)
+	}
+	if err != nil {
+		if s3.fallback != nil && isUnavailable(ctx, err) {
+			return s3.loadFallback(key, err)
+		}
+		return nil, err
+	}
+	s3.loaded(ctx, key, obj)
+	return obj.data, nil
+}
+
+// loaded handles an object that Load read from S3.
+func (s3 *S3) loaded(ctx context.Context, key string, obj loadedObject) {
+	// unencrypted objects cannot be read from the fallback directory
+	if obj.raw != nil && !obj.cleartext {
+		s3.storeFallback(s3.objName(key), bytes.NewReader(obj.raw))
+	}
+	if obj.cleartext {
+		s3.loadedCleartext(ctx, key, obj)
+	}
+}
+
+// loadedObject is an object read by load.
+type loadedObject struct {
+	data []byte
+	etag *string
+
+	// raw is the object as stored, if it is kept in the fallback directory.
+	raw []byte
+
+	// cleartext is set for unencrypted objects read with CleartextReads, and
+	// unverified if they were only accepted because they failed to decrypt.
+	cleartext  bool
+	unverified bool
+}
+
+// load reads the object of key. If ifNoneMatch is set and the object still
+// has that ETag, errNotModified is returned.
+func (s3 *S3) load(ctx context.Context, key string, ifNoneMatch *string) (loadedObject, error) {
+	objName := s3.objName(key)
+
 	input := &s3sdk.GetObjectInput{
-		Bucket: aws.String(s3.Bucket),
-		Key:    aws.String(objName),
+		Bucket:      aws.String(s3.Bucket),
+		Key:         aws.String(objName),
+		IfNoneMatch: ifNoneMatch,
 	}
+	s3.applySSEGet(input)
 
 	result, err := s3.Client.GetObject(ctx, input)
 	if err != nil {
 		var nsk *types.NoSuchKey
 		if errors.As(err, &nsk) {
-			return nil, fs.ErrNotExist
+			return loadedObject{}, fs.ErrNotExist
+		}
+		var re *awshttp.ResponseError
+		if ifNoneMatch != nil && errors.As(err, &re) && re.HTTPStatusCode() == http.StatusNotModified {
+			return loadedObject{}, errNotModified
 		}
-		return nil, fmt.Errorf("failed to load key %s: %w", key, err)
+		return loadedObject{}, fmt.Errorf("failed to load key %s: %w", key, err)
 	}
 	defer func() { _ = result.Body.Close() }()
 
-	buf, err := io.ReadAll(s3.iowrap.WrapReader(result.Body))
+	var body io.Reader = result.Body
+	var raw *bytes.Buffer
+	if s3.fallback != nil {
+		raw = new(bytes.Buffer)
+		body = io.TeeReader(result.Body, raw)
+	}
+
+	buf, cleartext, unverified, err := s3.readObject(objName, body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read/decrypt data for key %s: %w", key, err)
+		return loadedObject{}, fmt.Errorf("failed to read/decrypt data for key %s: %w", key, err)
+	}
+	obj := loadedObject{data: buf, etag: result.ETag, cleartext: cleartext, unverified: unverified}
+	if raw != nil {
+		if _, err := io.Copy(raw, result.Body); err != nil {
+			return loadedObject{}, fmt.Errorf("failed to read data for key %s: %w", key, err)
+		}
+		obj.raw = raw.Bytes()
 	}
-	return buf, nil
+	return obj, nil
 }
 
-func (s3 *S3) Delete(ctx context.Context, key string) error {
+func (s3 *S3) Delete(ctx context.Context, key string) (err error) {
 	start := time.Now()
 	objName := s3.objName(key)
+	ctx, op := s3.startOperation(ctx, opDelete, objName)
+	defer func() { op.end(0, err) }()
 
 	s3.Logger.Info("deleting object",
 		zap.String("key", objName),
@@ -330,28 +883,36 @@ func (s3 *S3) Delete(ctx context.Context, key string) error {
 		Key:    aws.String(objName),
 	}
 
-	_, err := s3.Client.DeleteObject(ctx, input)
+	if s3.cache != nil {
+		defer s3.cache.invalidate(objName)
+	}
+	defer s3.forget(objName)
+	out, err := s3.Client.DeleteObject(ctx, input)
+	if out == nil {
+		out = new(s3sdk.DeleteObjectOutput)
+	}
+	s3.audit(ctx, opDelete, objName, 0, nil, out.VersionId, err)
 	if err != nil {
 		return fmt.Errorf("failed to delete key %s: %w", key, err)
 	}
+	if s3.fallback != nil {
+		s3.removeFallback(objName)
+	}
 	return nil
 }
 
 func (s3 *S3) Exists(ctx context.Context, key string) bool {
 	objName := s3.objName(key)
+	ctx, op := s3.startOperation(ctx, opExists, objName)
 
 	s3.Logger.Debug("checking object existence",
 		zap.String("key", objName),
 		zap.String("bucket", s3.Bucket),
 	)
 
-	input := &s3sdk.HeadObjectInput{
-		Bucket: aws.String(s3.Bucket),
-		Key:    aws.String(objName),
-	}
-
-	_, err := s3.Client.HeadObject(ctx, input)
+	_, err := s3.headObject(ctx, objName)
 	exists := err == nil
+	op.end(0, err)
 
 	s3.Logger.Debug("existence check completed",
 		zap.String("key", objName),
@@ -361,8 +922,9 @@ func (s3 *S3) Exists(ctx context.Context, key string) bool {
 	return exists
 }
 
-func (s3 *S3) List(ctx context.Context, prefix string, recursive bool) ([]string, error) {
-	var keys []string
+func (s3 *S3) List(ctx context.Context, prefix string, recursive bool) (keys []string, err error) {
+	ctx, op := s3.startOperation(ctx, opList, s3.objName(prefix))
+	defer func() { op.end(0, err) }()
 
 	input := &s3sdk.ListObjectsV2Input{
 		Bucket: aws.String(s3.Bucket),
@@ -384,16 +946,12 @@ func (s3 *S3) List(ctx context.Context, prefix string, recursive bool) ([]string
 	return keys, nil
 }
 
-func (s3 *S3) Stat(ctx context.Context, key string) (certmagic.KeyInfo, error) {
+func (s3 *S3) Stat(ctx context.Context, key string) (ki certmagic.KeyInfo, err error) {
 	s3.Logger.Info(fmt.Sprintf("Stat: %v", s3.objName(key)))
-	var ki certmagic.KeyInfo
+	ctx, op := s3.startOperation(ctx, opStat, s3.objName(key))
+	defer func() { op.end(0, err) }()
 
-	input := &s3sdk.HeadObjectInput{
-		Bucket: aws.String(s3.Bucket),
-		Key:    aws.String(s3.objName(key)),
-	}
-
-	result, err := s3.Client.HeadObject(ctx, input)
+	result, err := s3.headObject(ctx, s3.objName(key))
 	if err != nil {
 		var nsk *types.NoSuchKey
 		if errors.As(err, &nsk) {
@@ -428,6 +986,20 @@ func (s3 *S3) CertMagicStorage() (certmagic.Storage, error) {
 	return s3, nil
 }
 
+// requestLimit returns the limit of budget to set in the Caddyfile.
+func (s3 *S3) requestLimit(d *caddyfile.Dispenser, budget string) (*RequestLimit, error) {
+	if !isValidBudget(budget) {
+		return nil, d.Errf("unknown request budget: %s", budget)
+	}
+	if s3.RequestLimits == nil {
+		s3.RequestLimits = make(map[string]*RequestLimit)
+	}
+	if s3.RequestLimits[budget] == nil {
+		s3.RequestLimits[budget] = new(RequestLimit)
+	}
+	return s3.RequestLimits[budget], nil
+}
+
 func parseBool(value string) (bool, error) {
 	return strconv.ParseBool(value)
 }
@@ -460,6 +1032,12 @@ func (s3 *S3) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 			s3.AccessKey = value
 		case "secret_key":
 			s3.SecretKey = value
+		case "secret_key_file":
+			s3.SecretKeyFile = value
+		case "session_token":
+			s3.SessionToken = value
+		case "credential_process":
+			s3.CredentialProcess = value
 		case "profile":
 			s3.Profile = value
 		case "role_arn":
@@ -467,10 +1045,175 @@ func (s3 *S3) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 		case "prefix":
 			s3.Prefix = value
 		case "encryption_key":
-			if value != "" && len(value) != 32 {
-				return d.Errf("encryption_key must be exactly 32 bytes, got %d", len(value))
-			}
 			s3.EncryptionKey = value
+		case "encryption_algorithm":
+			if _, err := ParseAlgorithm(value); err != nil {
+				return d.Errf("%v", err)
+			}
+			s3.EncryptionAlgorithm = value
+		case "encryption_legacy_secretbox":
+			parsed, err := parseBool(value)
+			if err != nil {
+				return d.Errf("invalid boolean value for 'encryption_legacy_secretbox': %v", err)
+			}
+			s3.EncryptionLegacySecretBox = parsed
+		case "encryption_key_encoding":
+			if !isValidKeyEncoding(value) {
+				return d.Errf("unknown encryption_key_encoding: %s", value)
+			}
+			s3.EncryptionKeyEncoding = value
+		case "encryption_key_file":
+			s3.EncryptionKeyFile = value
+		case "integrity_key":
+			s3.IntegrityKey = value
+		case "integrity_key_file":
+			s3.IntegrityKeyFile = value
+		case "encryption_keyring":
+			var key string
+			if !d.Args(&key) {
+				return d.ArgErr()
+			}
+			if s3.EncryptionKeyring == nil {
+				s3.EncryptionKeyring = make(map[string]string)
+			}
+			s3.EncryptionKeyring[value] = key
+		case "encryption_primary_key":
+			s3.EncryptionPrimaryKey = value
+		case "encryption_kms_key_id":
+			s3.EncryptionKMSKeyID = value
+		case "kms_endpoint":
+			s3.KMSEndpoint = value
+		case "encryption_recipients":
+			s3.EncryptionRecipients = append(s3.EncryptionRecipients, value)
+			s3.EncryptionRecipients = append(s3.EncryptionRecipients, d.RemainingArgs()...)
+		case "encryption_identity_file":
+			s3.EncryptionIdentityFile = value
+		case "vault_address":
+			s3.VaultAddress = value
+		case "vault_namespace":
+			s3.VaultNamespace = value
+		case "vault_transit_mount":
+			s3.VaultTransitMount = value
+		case "vault_transit_key":
+			s3.VaultTransitKey = value
+		case "vault_token":
+			s3.VaultToken = value
+		case "vault_token_file":
+			s3.VaultTokenFile = value
+		case "vault_approle_mount":
+			s3.VaultAppRoleMount = value
+		case "vault_role_id":
+			s3.VaultRoleID = value
+		case "vault_secret_id":
+			s3.VaultSecretID = value
+		case "sse":
+			if !isValidSSE(value) {
+				return d.Errf("unknown sse mode: %s", value)
+			}
+			s3.SSE = value
+		case "sse_kms_key_id":
+			s3.SSEKMSKeyID = value
+		case "sse_bucket_key":
+			parsed, err := parseBool(value)
+			if err != nil {
+				return d.Errf("invalid boolean value for 'sse_bucket_key': %v", err)
+			}
+			s3.SSEBucketKey = parsed
+		case "sse_customer_key":
+			s3.SSECustomerKey = value
+		case "compression":
+			if _, err := ParseCompression(value); err != nil {
+				return d.Errf("%v", err)
+			}
+			s3.Compression = value
+		case "compression_min_size":
+			size, err := strconv.Atoi(value)
+			if err != nil || size < 1 {
+				return d.Errf("invalid size for 'compression_min_size', must be at least 1: %s", value)
+			}
+			s3.CompressionMinSize = size
+		case "cleartext_reads":
+			if value != CleartextReadsDetect && value != CleartextReadsFallback {
+				return d.Errf("unknown cleartext_reads mode: %s", value)
+			}
+			s3.CleartextReads = value
+		case "cleartext_rewrite":
+			parsed, err := parseBool(value)
+			if err != nil {
+				return d.Errf("invalid boolean value for 'cleartext_rewrite': %v", err)
+			}
+			s3.CleartextRewrite = parsed
+		case "data_key_cache_ttl":
+			ttl, err := caddy.ParseDuration(value)
+			if err != nil {
+				return d.Errf("invalid duration for 'data_key_cache_ttl': %v", err)
+			}
+			s3.DataKeyCacheTTL = caddy.Duration(ttl)
+		case "audit":
+			if value != AuditLog && value != AuditBucket {
+				return d.Errf("unknown audit sink: %s", value)
+			}
+			s3.Audit = value
+		case "audit_prefix":
+			s3.AuditPrefix = value
+		case "audit_instance":
+			s3.AuditInstance = value
+		case "read_cache_size":
+			size, err := strconv.Atoi(value)
+			if err != nil || size < 0 {
+				return d.Errf("invalid value for 'read_cache_size': %s", value)
+			}
+			s3.ReadCacheSize = size
+		case "read_cache_ttl":
+			ttl, err := caddy.ParseDuration(value)
+			if err != nil {
+				return d.Errf("invalid duration for 'read_cache_ttl': %v", err)
+			}
+			s3.ReadCacheTTL = caddy.Duration(ttl)
+		case "rate_limit":
+			limit, err := s3.requestLimit(d, value)
+			if err != nil {
+				return err
+			}
+			args := d.RemainingArgs()
+			if len(args) == 0 || len(args) > 2 {
+				return d.ArgErr()
+			}
+			if limit.Rate, err = strconv.ParseFloat(args[0], 64); err != nil || limit.Rate <= 0 {
+				return d.Errf("invalid requests per second for 'rate_limit': %s", args[0])
+			}
+			if len(args) == 2 {
+				if limit.Burst, err = strconv.Atoi(args[1]); err != nil || limit.Burst <= 0 {
+					return d.Errf("invalid burst for 'rate_limit': %s", args[1])
+				}
+			}
+		case "max_in_flight":
+			limit, err := s3.requestLimit(d, value)
+			if err != nil {
+				return err
+			}
+			var n string
+			if !d.Args(&n) {
+				return d.ArgErr()
+			}
+			if limit.MaxInFlight, err = strconv.Atoi(n); err != nil || limit.MaxInFlight <= 0 {
+				return d.Errf("invalid value for 'max_in_flight': %s", n)
+			}
+		case "fallback_dir":
+			s3.FallbackDir = value
+		case "fallback_max_age":
+			maxAge, err := caddy.ParseDuration(value)
+			if err != nil {
+				return d.Errf("invalid duration for 'fallback_max_age': %v", err)
+			}
+			s3.FallbackMaxAge = caddy.Duration(maxAge)
+		case "encryption_passphrase":
+			s3.EncryptionPassphrase = value
+		case "encryption_kdf":
+			if value != KDFArgon2id && value != KDFScrypt {
+				return d.Errf("unknown encryption_kdf: %s", value)
+			}
+			s3.EncryptionKDF = value
 		case "use_path_style":
 			parsed, err := parseBool(value)
 			if err != nil {
@@ -493,6 +1236,66 @@ func (s3 *S3) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 		return d.Err("bucket is required")
 	}
 
+	if (s3.EncryptionKey != "" || s3.EncryptionKeyFile != "") && s3.EncryptionPassphrase != "" {
+		return d.Err("cannot specify both 'encryption_key' and 'encryption_passphrase' options")
+	}
+
+	if len(s3.EncryptionKeyring) > 0 && (s3.EncryptionKey != "" || s3.EncryptionKeyFile != "" || s3.EncryptionPassphrase != "") {
+		return d.Err("cannot combine 'encryption_keyring' with 'encryption_key' or 'encryption_passphrase'")
+	}
+	staticKeys := s3.EncryptionKey != "" || s3.EncryptionKeyFile != "" || s3.EncryptionPassphrase != "" || len(s3.EncryptionKeyring) > 0
+	ageKeys := len(s3.EncryptionRecipients) > 0 || s3.EncryptionIdentityFile != ""
+	if s3.EncryptionKMSKeyID != "" && (staticKeys || s3.VaultTransitKey != "" || ageKeys) {
+		return d.Err("cannot combine 'encryption_kms_key_id' with other encryption keys")
+	}
+	if s3.VaultTransitKey != "" && (staticKeys || ageKeys) {
+		return d.Err("cannot combine 'vault_transit_key' with other encryption keys")
+	}
+	if ageKeys && staticKeys {
+		return d.Err("cannot combine age recipients or identities with static encryption keys")
+	}
+	if (s3.IntegrityKey != "" || s3.IntegrityKeyFile != "") && (staticKeys || ageKeys || s3.EncryptionKMSKeyID != "" || s3.VaultTransitKey != "") {
+		return d.Err("cannot combine 'integrity_key' with encryption, which already detects modifications")
+	}
+	if _, err := ParseAgeRecipients(s3.EncryptionRecipients); err != nil {
+		return d.Errf("invalid encryption_recipients: %v", err)
+	}
+	if s3.EncryptionPrimaryKey != "" {
+		if _, ok := s3.EncryptionKeyring[s3.EncryptionPrimaryKey]; !ok {
+			return d.Errf("encryption_primary_key %q is not in the keyring", s3.EncryptionPrimaryKey)
+		}
+	}
+
+	// placeholders are only expanded at provision time
+	if s3.EncryptionKey != "" && !isEnvPlaceholder(s3.EncryptionKey) {
+		if _, err := decodeEncryptionKey(s3.EncryptionKey, s3.EncryptionKeyEncoding); err != nil {
+			return d.Errf("invalid encryption_key: %v", err)
+		}
+	}
+	if s3.IntegrityKey != "" && !isEnvPlaceholder(s3.IntegrityKey) {
+		if _, err := decodeEncryptionKey(s3.IntegrityKey, s3.EncryptionKeyEncoding); err != nil {
+			return d.Errf("invalid integrity_key: %v", err)
+		}
+	}
+	for id, key := range s3.EncryptionKeyring {
+		if !isEnvPlaceholder(key) {
+			if _, err := decodeEncryptionKey(key, s3.EncryptionKeyEncoding); err != nil {
+				return d.Errf("invalid key %q in encryption_keyring: %v", id, err)
+			}
+		}
+	}
+
+	if s3.SSE != SSEKMS && (s3.SSEKMSKeyID != "" || s3.SSEBucketKey) {
+		return d.Err("'sse_kms_key_id' and 'sse_bucket_key' require 'sse aws:kms'")
+	}
+	if (s3.SSE == SSECustomer) != (s3.SSECustomerKey != "") {
+		return d.Err("'sse SSE-C' requires 'sse_customer_key' and vice versa")
+	}
+
+	if s3.CleartextRewrite && s3.CleartextReads == "" {
+		return d.Err("'cleartext_rewrite' requires 'cleartext_reads'")
+	}
+
 	if s3.Host != "" && s3.Endpoint != "" {
 		return d.Err("cannot specify both 'host' and 'endpoint' options")
 	}
@@ -1,6 +1,7 @@
 package s3
 
 import (
+	"context"
 	"testing"
 )
 
@@ -96,3 +97,39 @@ func TestS3_UsePathStyleConfiguration(t *testing.T) {
 		})
 	}
 }
+
+func TestS3_credentialsProvider(t *testing.T) {
+	t.Run("no explicit credentials", func(t *testing.T) {
+		s3 := &S3{Profile: "default"}
+		if provider := s3.credentialsProvider(); provider != nil {
+			t.Errorf("credentialsProvider() = %v, want nil", provider)
+		}
+	})
+
+	t.Run("static credentials with session token", func(t *testing.T) {
+		s3 := &S3{AccessKey: "AKID", SecretKey: "SECRET", SessionToken: "TOKEN"}
+		creds, err := s3.credentialsProvider().Retrieve(context.Background())
+		if err != nil {
+			t.Fatalf("Retrieve() error = %v", err)
+		}
+		if creds.AccessKeyID != "AKID" || creds.SecretAccessKey != "SECRET" || creds.SessionToken != "TOKEN" {
+			t.Errorf("Retrieve() = %+v, want static credentials with session token", creds)
+		}
+	})
+
+	t.Run("credential process", func(t *testing.T) {
+		s3 := &S3{
+			CredentialProcess: `echo '{"Version": 1, "AccessKeyId": "PROCID", "SecretAccessKey": "PROCSECRET", "SessionToken": "PROCTOKEN", "Expiration": "2099-01-01T00:00:00Z"}'`,
+		}
+		creds, err := s3.credentialsProvider().Retrieve(context.Background())
+		if err != nil {
+			t.Fatalf("Retrieve() error = %v", err)
+		}
+		if creds.AccessKeyID != "PROCID" || creds.SessionToken != "PROCTOKEN" {
+			t.Errorf("Retrieve() = %+v, want credentials from process", creds)
+		}
+		if !creds.CanExpire {
+			t.Error("Retrieve() credentials from process should expire")
+		}
+	})
+}
@@ -0,0 +1,162 @@
+package s3
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// redactedSecret replaces secrets in the JSON encoding of a provisioned module.
+const redactedSecret = "[redacted]"
+
+type secretField struct {
+	name  string
+	value *string
+	file  string
+}
+
+// secretFields returns the configuration fields holding secrets, with the
+// files they may alternatively be read from.
+func (s3 *S3) secretFields() []secretField {
+	return []secretField{
+		{name: "access_key", value: &s3.AccessKey},
+		{name: "secret_key", value: &s3.SecretKey, file: s3.SecretKeyFile},
+		{name: "session_token", value: &s3.SessionToken},
+		{name: "encryption_key", value: &s3.EncryptionKey, file: s3.EncryptionKeyFile},
+		{name: "encryption_passphrase", value: &s3.EncryptionPassphrase},
+		{name: "integrity_key", value: &s3.IntegrityKey, file: s3.IntegrityKeyFile},
+		{name: "vault_token", value: &s3.VaultToken, file: s3.VaultTokenFile},
+		{name: "vault_secret_id", value: &s3.VaultSecretID},
+		{name: "sse_customer_key", value: &s3.SSECustomerKey},
+	}
+}
+
+// loadSecrets resolves the sensitive configuration values at provision time.
+// Values may be an {env.NAME} placeholder to read an environment variable,
+// and secret_key, encryption_key, integrity_key and vault_token may alternatively be read from files,
+// e.g. mounted Kubernetes or Docker secrets.
+func (s3 *S3) loadSecrets() error {
+	for _, secret := range s3.secretFields() {
+		if secret.file != "" {
+			if *secret.value != "" {
+				return fmt.Errorf("cannot specify both '%s' and '%s_file' options", secret.name, secret.name)
+			}
+			value, err := readSecretFile(secret.file)
+			if err != nil {
+				return fmt.Errorf("failed to read %s_file: %w", secret.name, err)
+			}
+			*secret.value = value
+			continue
+		}
+
+		value, err := expandSecret(*secret.value)
+		if err != nil {
+			return fmt.Errorf("failed to expand %s: %w", secret.name, err)
+		}
+		*secret.value = value
+	}
+
+	for id, key := range s3.EncryptionKeyring {
+		value, err := expandSecret(key)
+		if err != nil {
+			return fmt.Errorf("failed to expand encryption key %q: %w", id, err)
+		}
+		s3.EncryptionKeyring[id] = value
+	}
+
+	return nil
+}
+
+// expandSecret returns the environment variable NAME if value is exactly
+// {env.NAME}. Any other value is a literal secret and returned unchanged, as
+// keys and passwords may contain braces.
+func expandSecret(value string) (string, error) {
+	if !isEnvPlaceholder(value) {
+		return value, nil
+	}
+	name := value[len("{env.") : len(value)-1]
+	env := os.Getenv(name)
+	if env == "" {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return env, nil
+}
+
+// isEnvPlaceholder reports whether value is exactly an {env.NAME} placeholder.
+func isEnvPlaceholder(value string) bool {
+	name, ok := strings.CutPrefix(value, "{env.")
+	if !ok || !strings.HasSuffix(name, "}") {
+		return false
+	}
+	name = strings.TrimSuffix(name, "}")
+	return name != "" && !strings.ContainsAny(name, "{}")
+}
+
+// readSecretFile returns the content of the file at path without trailing newlines.
+func readSecretFile(path string) (string, error) {
+	buf, err := os.ReadFile(path) // #nosec G304 -- path is provided by the operator
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(buf), "\r\n"), nil
+}
+
+// clearSecrets removes the secrets from the configuration fields once the
+// module is provisioned. From then on, they are only held by the clients and
+// the IO implementation using them, and are redacted by MarshalJSON.
+func (s3 *S3) clearSecrets() {
+	for _, f := range s3.secretFields() {
+		if *f.value != "" {
+			*f.value = ""
+			s3.redacted = append(s3.redacted, f.name)
+		}
+	}
+	if len(s3.EncryptionKeyring) > 0 {
+		for id := range s3.EncryptionKeyring {
+			s3.EncryptionKeyring[id] = ""
+		}
+		s3.redacted = append(s3.redacted, "encryption_keyring")
+	}
+}
+
+// MarshalJSON encodes the configuration. Secrets that were cleared when the
+// module was provisioned are replaced with a placeholder, so that they are not
+// mistaken for being unset.
+func (s3 *S3) MarshalJSON() ([]byte, error) {
+	type config S3 // without this method
+	c := config(*s3)
+	for _, f := range (*S3)(&c).secretFields() {
+		if slices.Contains(s3.redacted, f.name) {
+			*f.value = redactedSecret
+		}
+	}
+	if slices.Contains(s3.redacted, "encryption_keyring") {
+		c.EncryptionKeyring = make(map[string]string, len(s3.EncryptionKeyring))
+		for id := range s3.EncryptionKeyring {
+			c.EncryptionKeyring[id] = redactedSecret
+		}
+	}
+	return json.Marshal(c)
+}
+
+// Cleanup wipes the key material held by the IO implementation, and the
+// decrypted values held by the read cache, when the module is unloaded.
+func (s3 *S3) Cleanup() error {
+	if w, ok := s3.iowrap.(wiper); ok {
+		w.wipe()
+	}
+	// the cache is kept, as loads may still be running during a config reload
+	if s3.cache != nil {
+		s3.cache.purge()
+	}
+	return nil
+}
+
+var (
+	_ caddy.CleanerUpper = (*S3)(nil)
+	_ json.Marshaler     = (*S3)(nil)
+)
@@ -0,0 +1,153 @@
+package s3
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testSSEKeyMD5() string {
+	sum := md5.Sum([]byte(testKeyStr)) // #nosec G401
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func TestS3_setupSSE(t *testing.T) {
+	tests := []struct {
+		name    string
+		s3      S3
+		wantErr string
+	}{
+		{name: "disabled"},
+		{name: "aes256", s3: S3{SSE: SSEAES256}},
+		{name: "kms", s3: S3{SSE: SSEKMS, SSEKMSKeyID: "alias/certs", SSEBucketKey: true}},
+		{name: "customer", s3: S3{SSE: SSECustomer, SSECustomerKey: testKeyStr}},
+		{name: "unknown", s3: S3{SSE: "aws:other"}, wantErr: "unknown sse mode"},
+		{name: "kms key without kms", s3: S3{SSE: SSEAES256, SSEKMSKeyID: "alias/certs"}, wantErr: "require 'sse aws:kms'"},
+		{name: "customer without key", s3: S3{SSE: SSECustomer}, wantErr: "requires 'sse_customer_key'"},
+		{name: "key without customer", s3: S3{SSECustomerKey: testKeyStr}, wantErr: "requires 'sse_customer_key'"},
+		{name: "invalid customer key", s3: S3{SSE: SSECustomer, SSECustomerKey: "short"}, wantErr: "invalid sse_customer_key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.s3.setupSSE()
+			if tt.wantErr == "" {
+				assertNoError(t, err, "setupSSE()")
+			} else {
+				assertError(t, err, tt.wantErr, "setupSSE()")
+			}
+		})
+	}
+}
+
+func TestS3_SSEHeaders(t *testing.T) {
+	tests := []struct {
+		name string
+		sse  S3
+		want map[string]string
+	}{
+		{
+			name: "aes256",
+			sse:  S3{SSE: SSEAES256},
+			want: map[string]string{"X-Amz-Server-Side-Encryption": "AES256"},
+		},
+		{
+			name: "kms",
+			sse:  S3{SSE: SSEKMS, SSEKMSKeyID: "alias/certs", SSEBucketKey: true},
+			want: map[string]string{
+				"X-Amz-Server-Side-Encryption":                    "aws:kms",
+				"X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id":     "alias/certs",
+				"X-Amz-Server-Side-Encryption-Bucket-Key-Enabled": "true",
+			},
+		},
+		{
+			name: "customer",
+			sse:  S3{SSE: SSECustomer, SSECustomerKey: testKeyStr},
+			want: map[string]string{
+				"X-Amz-Server-Side-Encryption-Customer-Algorithm": "AES256",
+				"X-Amz-Server-Side-Encryption-Customer-Key-Md5":   testSSEKeyMD5(),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s3, fake := newTestS3(t)
+			s3.SSE, s3.SSEKMSKeyID, s3.SSEBucketKey, s3.SSECustomerKey = tt.sse.SSE, tt.sse.SSEKMSKeyID, tt.sse.SSEBucketKey, tt.sse.SSECustomerKey
+			assertNoError(t, s3.setupSSE(), "setupSSE()")
+
+			ctx := context.Background()
+			assertNoError(t, s3.Store(ctx, "certificates/example.com", []byte("cert")), "Store()")
+			assertNoError(t, s3.Lock(ctx, "certificates/example.com"), "Lock()")
+
+			for _, key := range []string{"acme/certificates/example.com", "acme/certificates/example.com.lock"} {
+				header := fake.header(key)
+				for name, value := range tt.want {
+					// lock files must be readable without the customer key
+					if tt.sse.SSE == SSECustomer && strings.HasSuffix(key, ".lock") {
+						value = ""
+					}
+					if got := header.Get(name); got != value {
+						t.Errorf("%s: %s = %q, want %q", key, name, got, value)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestS3_SSECustomerReads(t *testing.T) {
+	ctx := context.Background()
+	s3, _ := newTestS3(t)
+	s3.SSE, s3.SSECustomerKey = SSECustomer, testKeyStr
+	assertNoError(t, s3.setupSSE(), "setupSSE()")
+
+	assertNoError(t, s3.Store(ctx, "certificates/example.com", []byte("cert")), "Store()")
+
+	buf, err := s3.Load(ctx, "certificates/example.com")
+	assertNoError(t, err, "Load()")
+	if string(buf) != "cert" {
+		t.Errorf("Load() = %q, want %q", buf, "cert")
+	}
+	_, err = s3.Stat(ctx, "certificates/example.com")
+	assertNoError(t, err, "Stat()")
+	if !s3.Exists(ctx, "certificates/example.com") {
+		t.Error("Exists() = false, want true")
+	}
+
+	// without the customer key, S3 refuses to read the object
+	other, _ := newTestS3(t)
+	other.Client = s3.Client
+	_, err = other.Load(ctx, "certificates/example.com")
+	assertError(t, err, "InvalidRequest", "Load() without customer key")
+	_, err = other.Stat(ctx, "certificates/example.com")
+	assertError(t, err, "", "Stat() without customer key")
+	if other.Exists(ctx, "certificates/example.com") {
+		t.Error("Exists() without customer key = true, want false")
+	}
+}
+
+func TestS3_SSECustomerLock(t *testing.T) {
+	ctx := context.Background()
+	s3, fake := newTestS3(t)
+	s3.SSE, s3.SSECustomerKey = SSECustomer, testKeyStr
+	assertNoError(t, s3.setupSSE(), "setupSSE()")
+
+	lockTimeout, pollInterval := LockTimeout, LockPollInterval
+	LockTimeout, LockPollInterval = 50*time.Millisecond, 10*time.Millisecond
+	t.Cleanup(func() { LockTimeout, LockPollInterval = lockTimeout, pollInterval })
+
+	// a lock file written with another customer key cannot be read, and is
+	// treated like a lock that is held
+	header := http.Header{}
+	header.Set("X-Amz-Server-Side-Encryption-Customer-Key-Md5", "other")
+	fake.mu.Lock()
+	fake.objects[s3.objLockName("site.crt")] = newFakeObject([]byte(time.Now().Format(time.RFC3339)), header)
+	fake.mu.Unlock()
+
+	assertError(t, s3.Lock(ctx, "site.crt"), "acquiring lock failed", "Lock()")
+}
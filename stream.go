@@ -0,0 +1,225 @@
+package s3
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	// StreamChunkSize is the size of the plaintext chunks of streamed objects.
+	StreamChunkSize = 64 << 10
+
+	streamSaltSize = 32
+	streamKeyInfo  = "certmagic-s3 stream"
+)
+
+var errStreamAuth = errors.New("decryption failed: invalid key, corrupted data or object was moved")
+
+// streamSealer encrypts msg into an object with EnvelopeVersionStream, chunk by
+// chunk while it is read, following the STREAM construction also used by age:
+//
+//	envelope | salt (32 bytes) | chunk 0 | chunk 1 | ... | chunk n
+//
+// Every chunk holds StreamChunkSize bytes of plaintext, except for the last
+// one, which may be shorter or even empty. The chunks are encrypted with a key
+// derived from the key and salt using HKDF-SHA256, with the chunk counter and a
+// flag marking the last chunk as nonce, so chunks cannot be reordered, dropped
+// or truncated. Each chunk authenticates the envelope and the object name.
+//
+// Chunks are sealed deterministically, so the sealer can be rewound for retries.
+type streamSealer struct {
+	aead   cipher.AEAD
+	header []byte // envelope and salt
+	ad     []byte
+	msg    []byte
+
+	pos   int64
+	size  int64
+	chunk int
+	buf   []byte
+}
+
+// newStreamSealer returns the encrypted object for msg as a Reader.
+func newStreamSealer(newAEAD func([]byte) (cipher.AEAD, error), key [32]byte, algorithm Algorithm, name string, msg []byte, keyID string) Reader {
+	header := appendEnvelopeVersion(nil, EnvelopeVersionStream, algorithm, keyID)
+	envLen := len(header)
+	header = append(header, make([]byte, streamSaltSize)...)
+	if _, err := io.ReadFull(rand.Reader, header[envLen:]); err != nil {
+		return Reader{nil, 0, err}
+	}
+
+	aead, err := streamAEAD(newAEAD, key, header[envLen:])
+	if err != nil {
+		return Reader{nil, 0, err}
+	}
+
+	s := &streamSealer{
+		aead:   aead,
+		header: header,
+		ad:     associatedData(header[:envLen], name),
+		msg:    msg,
+		chunk:  -1,
+	}
+	s.size = int64(len(header)) + int64(len(msg)) + int64(streamChunks(len(msg))*aead.Overhead())
+	return Reader{s, s.size, nil}
+}
+
+// streamChunks returns the number of chunks of a message of n bytes.
+func streamChunks(n int) int {
+	if n == 0 {
+		return 1
+	}
+	return (n + StreamChunkSize - 1) / StreamChunkSize
+}
+
+func (s *streamSealer) Read(p []byte) (int, error) {
+	if s.pos >= s.size {
+		return 0, io.EOF
+	}
+	if s.pos < int64(len(s.header)) {
+		n := copy(p, s.header[s.pos:])
+		s.pos += int64(n)
+		return n, nil
+	}
+
+	sealedSize := int64(StreamChunkSize + s.aead.Overhead())
+	off := s.pos - int64(len(s.header))
+	chunk := int(off / sealedSize)
+	if chunk != s.chunk {
+		start := chunk * StreamChunkSize
+		end := min(start+StreamChunkSize, len(s.msg))
+		last := chunk == streamChunks(len(s.msg))-1
+		s.buf = s.aead.Seal(s.buf[:0], streamNonce(s.aead, uint64(chunk), last), s.msg[start:end], s.ad)
+		s.chunk = chunk
+	}
+
+	n := copy(p, s.buf[off-int64(chunk)*sealedSize:])
+	s.pos += int64(n)
+	return n, nil
+}
+
+func (s *streamSealer) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += s.pos
+	case io.SeekEnd:
+		offset += s.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	s.pos = offset
+	return offset, nil
+}
+
+// streamOpener decrypts the chunks following the salt of a streamed object
+// one at a time while it is read.
+type streamOpener struct {
+	aead cipher.AEAD
+	ad   []byte
+	r    *bufio.Reader
+
+	chunk  uint64
+	sealed []byte
+	buf    []byte
+	done   bool
+	err    error
+}
+
+// newStreamOpener returns a reader for the plaintext of the streamed object
+// with the given envelope header, the payload of which is read from r.
+func newStreamOpener(newAEAD func([]byte) (cipher.AEAD, error), key [32]byte, name string, header []byte, r io.Reader) io.Reader {
+	salt := make([]byte, streamSaltSize)
+	if _, err := io.ReadFull(r, salt); err != nil {
+		return &Reader{nil, 0, errors.New("insufficient data for decryption: missing salt")}
+	}
+
+	aead, err := streamAEAD(newAEAD, key, salt)
+	if err != nil {
+		return &Reader{nil, 0, err}
+	}
+
+	return &streamOpener{
+		aead:   aead,
+		ad:     associatedData(header, name),
+		r:      bufio.NewReader(r),
+		sealed: make([]byte, StreamChunkSize+aead.Overhead()),
+	}
+}
+
+func (s *streamOpener) Read(p []byte) (int, error) {
+	for len(s.buf) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		if s.done {
+			return 0, io.EOF
+		}
+		s.err = s.next()
+	}
+
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+// next decrypts the next chunk into buf.
+func (s *streamOpener) next() error {
+	n, err := io.ReadFull(s.r, s.sealed)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	// a full chunk is the last one if nothing follows it
+	last := n < len(s.sealed)
+	if !last {
+		if _, err := s.r.Peek(1); errors.Is(err, io.EOF) {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	buf, err := s.aead.Open(s.buf[:0], streamNonce(s.aead, s.chunk, last), s.sealed[:n], s.ad)
+	if err != nil {
+		return errStreamAuth
+	}
+	s.buf = buf
+	s.chunk++
+	s.done = last
+	return nil
+}
+
+// streamAEAD returns the AEAD for the object key derived from key and salt.
+func streamAEAD(newAEAD func([]byte) (cipher.AEAD, error), key [32]byte, salt []byte) (cipher.AEAD, error) {
+	objKey, err := hkdf.Key(sha256.New, key[:], salt, streamKeyInfo, len(key))
+	if err != nil {
+		return nil, err
+	}
+	return newAEAD(objKey)
+}
+
+// streamNonce returns the nonce of the chunk with the given counter: the big
+// endian counter followed by 1 for the last chunk, 0 otherwise.
+func streamNonce(aead cipher.AEAD, chunk uint64, last bool) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-9:], chunk)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+var (
+	_ io.ReadSeeker = (*streamSealer)(nil)
+	_ io.Reader     = (*streamOpener)(nil)
+)
@@ -0,0 +1,147 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+func TestStreamIO(t *testing.T) {
+	sizes := []int{0, 1, StreamChunkSize - 1, StreamChunkSize, StreamChunkSize + 1, 3*StreamChunkSize + 5}
+
+	for _, algorithm := range []Algorithm{AlgorithmXChaCha20Poly1305, AlgorithmAES256GCM} {
+		c, err := newKeyedCipher(algorithm, testKey32)
+		assertNoError(t, err, "newKeyedCipher()")
+
+		for _, size := range sizes {
+			msg := make([]byte, size)
+			_, _ = rand.Read(msg)
+
+			r := c.ByteReader(testObjName, msg)
+			ciphertext, err := io.ReadAll(&r)
+			assertNoError(t, err, "encrypting")
+			if int64(len(ciphertext)) != r.Len() {
+				t.Errorf("%s, %d bytes: Len() = %d, but read %d bytes", algorithm, size, r.Len(), len(ciphertext))
+			}
+			if env, _, _ := ParseEnvelope(ciphertext); env.Version != EnvelopeVersionStream {
+				t.Errorf("%s, %d bytes: envelope version = %d, want %d", algorithm, size, env.Version, EnvelopeVersionStream)
+			}
+
+			buf, err := io.ReadAll(c.WrapReader(testObjName, bytes.NewReader(ciphertext)))
+			assertNoError(t, err, "decrypting")
+			if !bytes.Equal(buf, msg) {
+				t.Errorf("%s, %d bytes: decrypted data differs", algorithm, size)
+			}
+		}
+	}
+}
+
+func TestStreamIO_Seek(t *testing.T) {
+	c, err := NewXChaChaIO(testKey32)
+	assertNoError(t, err, "NewXChaChaIO()")
+	msg := bytes.Repeat([]byte("0123456789"), StreamChunkSize/4)
+
+	r := c.ByteReader(testObjName, msg)
+	first, err := io.ReadAll(&r)
+	assertNoError(t, err, "encrypting")
+
+	// the SDK rewinds the body to retry uploads
+	_, err = r.Seek(0, io.SeekStart)
+	assertNoError(t, err, "Seek()")
+	second, err := io.ReadAll(&r)
+	assertNoError(t, err, "encrypting again")
+	if !bytes.Equal(first, second) {
+		t.Error("reading again after Seek() returned different data")
+	}
+
+	_, err = r.Seek(int64(len(first))-10, io.SeekStart)
+	assertNoError(t, err, "Seek()")
+	tail, err := io.ReadAll(&r)
+	assertNoError(t, err, "reading tail")
+	if !bytes.Equal(tail, first[len(first)-10:]) {
+		t.Error("reading after Seek() into the last chunk returned different data")
+	}
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+func TestStreamIO_Incremental(t *testing.T) {
+	c, err := NewAESGCMIO(testKey32)
+	assertNoError(t, err, "NewAESGCMIO()")
+	msg := make([]byte, 4*StreamChunkSize)
+
+	r := c.ByteReader(testObjName, msg)
+	ciphertext, err := io.ReadAll(&r)
+	assertNoError(t, err, "encrypting")
+
+	body := &countingReader{r: bytes.NewReader(ciphertext)}
+	dr := c.WrapReader(testObjName, body)
+	_, err = io.ReadFull(dr, make([]byte, 10))
+	assertNoError(t, err, "reading first bytes")
+	if body.n >= len(ciphertext)/2 {
+		t.Errorf("read %d of %d bytes of the object for the first chunk", body.n, len(ciphertext))
+	}
+}
+
+func TestStreamIO_Tampering(t *testing.T) {
+	c, err := NewXChaChaIO(testKey32)
+	assertNoError(t, err, "NewXChaChaIO()")
+	msg := make([]byte, 2*StreamChunkSize)
+
+	r := c.ByteReader(testObjName, msg)
+	ciphertext, err := io.ReadAll(&r)
+	assertNoError(t, err, "encrypting")
+
+	sealedChunk := StreamChunkSize + chacha20poly1305.Overhead
+	start := envelopeLen("") + streamSaltSize
+	first := ciphertext[start : start+sealedChunk]
+	second := ciphertext[start+sealedChunk:]
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "truncated", data: ciphertext[:len(ciphertext)-1]},
+		{name: "last chunk dropped", data: ciphertext[:start+sealedChunk]},
+		{name: "chunks swapped", data: append(append(bytes.Clone(ciphertext[:start]), second...), first...)},
+		{name: "missing salt", data: ciphertext[:start+10]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := io.ReadAll(c.WrapReader(testObjName, bytes.NewReader(tt.data)))
+			assertError(t, err, "", "decrypting")
+		})
+	}
+
+	t.Run("moved", func(t *testing.T) {
+		_, err := io.ReadAll(c.WrapReader("acme/other", bytes.NewReader(ciphertext)))
+		assertError(t, err, "object was moved", "decrypting under another name")
+	})
+}
+
+func TestStreamIO_OnePieceObject(t *testing.T) {
+	c, err := NewXChaChaIO(testKey32)
+	assertNoError(t, err, "NewXChaChaIO()")
+	msg := []byte("encrypted before streaming")
+
+	sealed, err := aeadSeal(c.aead, AlgorithmXChaCha20Poly1305, testObjName, msg, "")
+	assertNoError(t, err, "aeadSeal()")
+	buf, err := io.ReadAll(c.WrapReader(testObjName, bytes.NewReader(sealed)))
+	assertNoError(t, err, "decrypting")
+	if !bytes.Equal(buf, msg) {
+		t.Errorf("decrypted = %q, want %q", buf, msg)
+	}
+}
@@ -0,0 +1,113 @@
+package s3
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	s3sdk "github.com/aws/aws-sdk-go-v2/service/s3"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/techknowlogick/certmagic-s3"
+
+// Span attributes of storage operations.
+const (
+	attrOperation = attribute.Key("certmagic.storage.operation")
+	attrResult    = attribute.Key("certmagic.storage.result")
+	attrBytes     = attribute.Key("certmagic.storage.bytes")
+	attrContended = attribute.Key("certmagic.storage.lock_contended")
+	attrBucket    = attribute.Key("aws.s3.bucket")
+	attrKey       = attribute.Key("aws.s3.key")
+)
+
+// tracing returns the provider of the spans created by s3, which is the
+// global provider unless set otherwise.
+func (s3 *S3) tracing() trace.TracerProvider {
+	if s3.tracerProvider != nil {
+		return s3.tracerProvider
+	}
+	return otel.GetTracerProvider()
+}
+
+// operation records the metrics and the span of a storage operation.
+type operation struct {
+	s3    *S3
+	name  string
+	start time.Time
+	span  trace.Span
+}
+
+// startOperation starts a span for the operation on objName as a child of
+// the span in ctx, and returns the context to pass to S3 requests.
+func (s3 *S3) startOperation(ctx context.Context, name, objName string) (context.Context, *operation) {
+	ctx, span := s3.tracing().Tracer(tracerName).Start(ctx, "s3."+name,
+		trace.WithAttributes(
+			attrOperation.String(name),
+			attrBucket.String(s3.Bucket),
+			attrKey.String(objName),
+		),
+	)
+	return ctx, &operation{s3: s3, name: name, start: time.Now(), span: span}
+}
+
+// end records that the operation either transferred size bytes or failed
+// with err if it is not nil.
+func (op *operation) end(size int, err error) {
+	op.s3.metrics.observe(op.name, op.start, size, err)
+
+	if size > 0 && err == nil {
+		op.span.SetAttributes(attrBytes.Int(size))
+	}
+	result := "ok"
+	if err != nil {
+		result = errorClass(err)
+		// missing objects are expected, e.g. when checking for existence
+		if result != "not_found" {
+			op.span.RecordError(err)
+			op.span.SetStatus(codes.Error, err.Error())
+		}
+	}
+	op.span.SetAttributes(attrResult.String(result))
+	op.span.End()
+}
+
+// withTracing instruments the HTTP client of an S3 client, so that every
+// request, including retries, is recorded as a span.
+func (s3 *S3) withTracing(o *s3sdk.Options) {
+	next := o.HTTPClient
+	if next == nil {
+		next = awshttp.NewBuildableClient()
+	}
+
+	o.HTTPClient = tracingHTTPClient{otelhttp.NewTransport(roundTripperFunc(next.Do),
+		otelhttp.WithTracerProvider(s3.tracing()),
+		// requests are signed already, and S3 has no use for trace headers
+		otelhttp.WithPropagators(propagation.NewCompositeTextMapPropagator()),
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return "S3." + awsmiddleware.GetOperationName(r.Context())
+		}),
+	)}
+}
+
+// tracingHTTPClient sends requests through an instrumented transport.
+type tracingHTTPClient struct {
+	rt http.RoundTripper
+}
+
+func (c tracingHTTPClient) Do(r *http.Request) (*http.Response, error) {
+	return c.rt.RoundTrip(r)
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
@@ -0,0 +1,112 @@
+package s3
+
+import (
+	"context"
+	"testing"
+
+	s3sdk "github.com/aws/aws-sdk-go-v2/service/s3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// newTracedTestS3 returns a test storage recording its spans.
+func newTracedTestS3(t *testing.T) (*S3, *fakeS3, *tracetest.SpanRecorder) {
+	s3, fake := newTestS3(t)
+	recorder := tracetest.NewSpanRecorder()
+	s3.tracerProvider = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	s3.Client = s3sdk.New(s3.Client.Options(), s3.withTracing)
+	return s3, fake, recorder
+}
+
+// spanAttr returns the value of the attribute key of span.
+func spanAttr(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestS3_tracing(t *testing.T) {
+	ctx := context.Background()
+	cert := []byte("-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n")
+
+	t.Run("Load", func(t *testing.T) {
+		s3, _, recorder := newTracedTestS3(t)
+		assertNoError(t, s3.Store(ctx, "site.crt", cert), "Store()")
+
+		parentCtx, parent := s3.tracing().Tracer("test").Start(ctx, "handshake")
+		_, err := s3.Load(parentCtx, "site.crt")
+		assertNoError(t, err, "Load()")
+		parent.End()
+
+		spans := recorder.Ended()
+		byName := make(map[string]sdktrace.ReadOnlySpan)
+		for _, span := range spans {
+			byName[span.Name()] = span
+		}
+
+		load, ok := byName["s3.load"]
+		if !ok {
+			t.Fatalf("no s3.load span in %d spans", len(spans))
+		}
+		if load.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Error("s3.load is not a child of the caller's span")
+		}
+		if got := spanAttr(load, attrKey).AsString(); got != s3.objName("site.crt") {
+			t.Errorf("key = %q, want %q", got, s3.objName("site.crt"))
+		}
+		if got := spanAttr(load, attrBytes).AsInt64(); got != int64(len(cert)) {
+			t.Errorf("bytes = %d, want %d", got, len(cert))
+		}
+		if got := spanAttr(load, attrResult).AsString(); got != "ok" {
+			t.Errorf("result = %q, want ok", got)
+		}
+
+		get, ok := byName["S3.GetObject"]
+		if !ok {
+			t.Fatal("no span for the GetObject request")
+		}
+		if get.Parent().SpanID() != load.SpanContext().SpanID() {
+			t.Error("GetObject request is not a child of s3.load")
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		s3, fake, recorder := newTracedTestS3(t)
+		if s3.Exists(ctx, "missing.crt") {
+			t.Fatal("Exists() = true")
+		}
+		fake.setFailing(true)
+		_, err := s3.Load(ctx, "site.crt")
+		assertError(t, err, "failed to load key", "Load()")
+		assertError(t, s3.Store(ctx, "site.crt", cert), "failed to store key", "Store()")
+
+		var checked int
+		for _, span := range recorder.Ended() {
+			switch span.Name() {
+			case "s3.exists":
+				checked++
+				if span.Status().Code == codes.Error || spanAttr(span, attrResult).AsString() != "not_found" {
+					t.Errorf("s3.exists status = %v, result = %v", span.Status(), spanAttr(span, attrResult).AsString())
+				}
+			case "s3.load":
+				checked++
+				if span.Status().Code != codes.Error || spanAttr(span, attrResult).AsString() != "throttled" {
+					t.Errorf("s3.load status = %v, result = %v", span.Status(), spanAttr(span, attrResult).AsString())
+				}
+			case "s3.store":
+				checked++
+				if span.Status().Code != codes.Error || spanAttr(span, attrBytes).Type() != attribute.INVALID {
+					t.Errorf("s3.store status = %v, bytes = %v", span.Status(), spanAttr(span, attrBytes).Emit())
+				}
+			}
+		}
+		if checked != 3 {
+			t.Errorf("checked %d spans, want 3", checked)
+		}
+	})
+}
@@ -0,0 +1,212 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VaultIO implements envelope encryption with the transit secrets engine of
+// HashiCorp Vault: every object is encrypted with its own data key, which is
+// generated by Vault and stored in the object wrapped by the transit key.
+type VaultIO struct {
+	dataKeyIO
+}
+
+// NewVaultIO returns a VaultIO that wraps data keys using client. Unwrapped
+// data keys are cached for cacheTTL, caching is disabled if it is not positive.
+func NewVaultIO(client *VaultClient, cacheTTL time.Duration) *VaultIO {
+	return &VaultIO{newDataKeyIO(AlgorithmVaultTransit, client, cacheTTL)}
+}
+
+// VaultClient talks to the transit secrets engine over Vault's HTTP API. It
+// authenticates with a static token, or logs in with AppRole if a role ID is set.
+type VaultClient struct {
+	HTTPClient *http.Client
+
+	Address      string
+	Namespace    string
+	TransitMount string
+	TransitKey   string
+
+	Token string
+
+	AppRoleMount string
+	RoleID       string
+	SecretID     string
+
+	mu           sync.Mutex
+	loginToken   string
+	tokenExpires time.Time
+}
+
+const (
+	defaultVaultTransitMount = "transit"
+	defaultVaultAppRoleMount = "approle"
+)
+
+func (vc *VaultClient) generateDataKey(ctx context.Context, _ string) ([]byte, []byte, error) {
+	var result struct {
+		Plaintext  string `json:"plaintext"`
+		Ciphertext string `json:"ciphertext"`
+	}
+	err := vc.request(ctx, "datakey/plaintext", map[string]any{"bits": 256}, &result)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	plaintext, err := base64.StdEncoding.DecodeString(result.Plaintext)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid data key from vault: %w", err)
+	}
+	return plaintext, []byte(result.Ciphertext), nil
+}
+
+func (vc *VaultClient) unwrapDataKey(ctx context.Context, _ string, wrapped []byte) ([]byte, error) {
+	var result struct {
+		Plaintext string `json:"plaintext"`
+	}
+	err := vc.request(ctx, "decrypt", map[string]any{"ciphertext": string(wrapped)}, &result)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(result.Plaintext)
+}
+
+// request calls the transit endpoint for the configured key and decodes the
+// data of the response into result. An expired AppRole login is renewed once.
+func (vc *VaultClient) request(ctx context.Context, endpoint string, body, result any) error {
+	mount := vc.TransitMount
+	if mount == "" {
+		mount = defaultVaultTransitMount
+	}
+	path := "/v1/" + strings.Trim(mount, "/") + "/" + endpoint + "/" + url.PathEscape(vc.TransitKey)
+
+	for attempt := 0; ; attempt++ {
+		token, err := vc.token(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = vc.do(ctx, path, token, body, &struct {
+			Data any `json:"data"`
+		}{result})
+
+		var statusErr *vaultStatusError
+		if attempt == 0 && vc.RoleID != "" && errors.As(err, &statusErr) && statusErr.status == http.StatusForbidden {
+			vc.mu.Lock()
+			vc.loginToken = ""
+			vc.mu.Unlock()
+			continue
+		}
+		return err
+	}
+}
+
+// token returns the token to authenticate with, logging in with AppRole if needed.
+func (vc *VaultClient) token(ctx context.Context) (string, error) {
+	if vc.RoleID == "" {
+		return vc.Token, nil
+	}
+
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+
+	if vc.loginToken != "" && (vc.tokenExpires.IsZero() || time.Now().Before(vc.tokenExpires)) {
+		return vc.loginToken, nil
+	}
+
+	mount := vc.AppRoleMount
+	if mount == "" {
+		mount = defaultVaultAppRoleMount
+	}
+
+	var result struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int    `json:"lease_duration"`
+		} `json:"auth"`
+	}
+	err := vc.do(ctx, "/v1/auth/"+strings.Trim(mount, "/")+"/login", "",
+		map[string]string{"role_id": vc.RoleID, "secret_id": vc.SecretID}, &result)
+	if err != nil {
+		return "", fmt.Errorf("vault approle login failed: %w", err)
+	}
+
+	vc.loginToken = result.Auth.ClientToken
+	vc.tokenExpires = time.Time{}
+	if result.Auth.LeaseDuration > 0 {
+		// renew shortly before the token expires
+		lease := time.Duration(result.Auth.LeaseDuration) * time.Second
+		vc.tokenExpires = time.Now().Add(lease - lease/10)
+	}
+	return vc.loginToken, nil
+}
+
+type vaultStatusError struct {
+	status int
+	errors []string
+}
+
+func (e *vaultStatusError) Error() string {
+	if len(e.errors) == 0 {
+		return fmt.Sprintf("vault responded with status %d", e.status)
+	}
+	return fmt.Sprintf("vault responded with status %d: %s", e.status, strings.Join(e.errors, "; "))
+}
+
+func (vc *VaultClient) do(ctx context.Context, path, token string, body, result any) error {
+	buf, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(vc.Address, "/")+path, bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if vc.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", vc.Namespace)
+	}
+
+	client := vc.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		statusErr := &vaultStatusError{status: resp.StatusCode}
+		var errResp struct {
+			Errors []string `json:"errors"`
+		}
+		if json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&errResp) == nil {
+			statusErr.errors = errResp.Errors
+		}
+		return statusErr
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+var (
+	_ IO              = (*VaultIO)(nil)
+	_ dataKeyProvider = (*VaultClient)(nil)
+)